@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"ldap-password-reset/service"
 
+	_ "github.com/gogf/gf/contrib/nosql/redis/v2"
 	"github.com/gogf/gf/os/gctx"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/net/ghttp"
@@ -24,8 +25,17 @@ func main() {
 		"level":  level,  // 日志级别（all, debug, info, notice, warning, error, critical）
 		"stdout": stdout, // 是否输出到控制台
 	})
+	// 日志脱敏，避免验证码、密码及完整联系方式写入日志
+	// 只作用于业务日志，审计日志需要保留原始的 JSON 记录
+	g.Log().SetHandlers(service.RedactLogHandler)
 	g.Log().Info(gctx.New(), "程序启动...")
 
+	// 加载泄露密码库
+	if err := service.LoadBreachedPasswords(); err != nil {
+		g.Log().Error(gctx.New(), "加载泄露密码库失败:", err)
+		return
+	}
+
 	portVar, err := g.Cfg().Get(context.Background(), "server.port")
 	if err != nil {
 		fmt.Println("Error reading config:", err)
@@ -36,8 +46,9 @@ func main() {
 	s := g.Server()
 	s.SetAddr(fmt.Sprintf(":%d", port))
 
-	// 验证码路径绑定
-	s.AddStaticPath("/captcha", "images")
+	// 接口限流
+	s.BindMiddleware("/api/*", service.RateLimitMiddleware)
+
 	s.AddStaticPath("/static", "public")
 	s.SetServerRoot("public") // 静态文件目录为 public
 
@@ -64,6 +75,15 @@ func main() {
 		service.GenerateCaptcha(r)
 	})
 
+	// 图形验证码图片，从内存渲染
+	s.BindHandler("/api/captcha/{id}.png", func(r *ghttp.Request) {
+		if r.Method != "GET" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Invalid request method"})
+			return
+		}
+		service.CaptchaImage(r)
+	})
+
 	// 公钥
 	s.BindHandler("/api/public-key", func(r *ghttp.Request) {
 		if r.Method != "GET" {
@@ -73,6 +93,15 @@ func main() {
 		service.GetPublicKey(r)
 	})
 
+	// 密码策略
+	s.BindHandler("/api/password-policy", func(r *ghttp.Request) {
+		if r.Method != "GET" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Invalid request method"})
+			return
+		}
+		service.GetPasswordPolicyInfo(r)
+	})
+
 	// 发送验证码
 	s.BindHandler("/api/send-code", func(r *ghttp.Request) {
 		if r.Method != "POST" {
@@ -113,12 +142,6 @@ func main() {
 			r.Response.WriteJsonExit(g.Map{"success": false, "error": "invalid request method"})
 			return
 		}
-		// 图形验证码答案
-		verifyCode := r.Get("verifyCode").String()
-		if verifyCode == "" {
-			r.Response.WriteJsonExit(g.Map{"success": false, "error": "verifyCode is required"})
-			return
-		}
 		// 用户名称
 		username := r.Get("username").String()
 		if username == "" {
@@ -131,6 +154,16 @@ func main() {
 			r.Response.WriteJsonExit(g.Map{"success": false, "error": "type is required"})
 			return
 		}
+		// 安全问题提交答案，其他方式提交验证码
+		if codetype == "questions" {
+			if r.Get("answers").IsEmpty() {
+				r.Response.WriteJsonExit(g.Map{"success": false, "error": "answers is required"})
+				return
+			}
+		} else if r.Get("verifyCode").String() == "" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "verifyCode is required"})
+			return
+		}
 		service.VerificationCode(r)
 	})
 
@@ -139,16 +172,58 @@ func main() {
 			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Invalid request method"})
 			return
 		}
-		// 验证类型
-		identifier := r.Get("type").String()
-		if identifier == "" {
-			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Identifier is required"})
+		// 验证码校验通过后签发的重置令牌
+		token := r.Get("token").String()
+		if token == "" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Token is required"})
+			return
+		}
+		// 用户名称
+		username := r.Get("username").String()
+		if username == "" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Username is required"})
 			return
 		}
-		// 收到的验证码
-		code := r.Get("verifyCode").String()
-		if code == "" {
-			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Code is required"})
+		// 新的密码
+		newPassword := r.Get("newPassword").String()
+		if newPassword == "" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "New password is required"})
+			return
+		}
+		service.ResetPassword(r)
+	})
+
+	// 解锁账户
+	s.BindHandler("/api/unlock-account", func(r *ghttp.Request) {
+		if r.Method != "POST" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Invalid request method"})
+			return
+		}
+		// 验证码校验通过后签发的重置令牌
+		token := r.Get("token").String()
+		if token == "" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Token is required"})
+			return
+		}
+		// 用户名称
+		username := r.Get("username").String()
+		if username == "" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Username is required"})
+			return
+		}
+		service.UnlockAccount(r)
+	})
+
+	// 生成待绑定的 TOTP 密钥
+	s.BindHandler("/api/totp/enroll", func(r *ghttp.Request) {
+		if r.Method != "POST" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Invalid request method"})
+			return
+		}
+		// 通过其他验证方式签发的重置令牌
+		token := r.Get("token").String()
+		if token == "" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Token is required"})
 			return
 		}
 		// 用户名称
@@ -157,14 +232,117 @@ func main() {
 			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Username is required"})
 			return
 		}
+		service.EnrollTOTP(r)
+	})
+
+	// 校验验证器口令，完成 TOTP 绑定
+	s.BindHandler("/api/totp/confirm", func(r *ghttp.Request) {
+		if r.Method != "POST" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Invalid request method"})
+			return
+		}
+		// 通过其他验证方式签发的重置令牌
+		token := r.Get("token").String()
+		if token == "" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Token is required"})
+			return
+		}
+		// 用户名称
+		username := r.Get("username").String()
+		if username == "" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Username is required"})
+			return
+		}
+		// 绑定接口返回的待确认密钥
+		enrollment := r.Get("enrollment").String()
+		if enrollment == "" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Enrollment is required"})
+			return
+		}
+		// 验证器当前显示的口令
+		totpCode := r.Get("totpCode").String()
+		if totpCode == "" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "totpCode is required"})
+			return
+		}
+		service.ConfirmTOTP(r)
+	})
+
+	// 可供设置的安全问题
+	s.BindHandler("/api/security-questions", func(r *ghttp.Request) {
+		if r.Method != "GET" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Invalid request method"})
+			return
+		}
+		service.ListSecurityQuestions(r)
+	})
+
+	// 设置安全问题
+	s.BindHandler("/api/security-questions/enroll", func(r *ghttp.Request) {
+		if r.Method != "POST" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Invalid request method"})
+			return
+		}
+		// 用户名称
+		username := r.Get("username").String()
+		if username == "" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Username is required"})
+			return
+		}
+		// 其他方式签发的重置令牌，或当前密码(需要公钥加密)
+		if r.Get("token").String() == "" && r.Get("password").String() == "" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Token or password is required"})
+			return
+		}
+		// 问题 ID 到答案的映射
+		if r.Get("answers").IsEmpty() {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Answers is required"})
+			return
+		}
+		service.EnrollSecurityQuestions(r)
+	})
+
+	// 凭当前密码修改密码
+	s.BindHandler("/api/change-password", func(r *ghttp.Request) {
+		if r.Method != "POST" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Invalid request method"})
+			return
+		}
+		// 图形验证码ID
+		verifyID := r.Get("verifyID").String()
+		if verifyID == "" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "verifyID is required"})
+			return
+		}
+		// 图形验证码答案
+		verifyCode := r.Get("verifyCode").String()
+		if verifyCode == "" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "verifyCode is required"})
+			return
+		}
+		// 用户名称
+		username := r.Get("username").String()
+		if username == "" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Username is required"})
+			return
+		}
+		// 当前密码
+		oldPassword := r.Get("oldPassword").String()
+		if oldPassword == "" {
+			r.Response.WriteJsonExit(g.Map{"success": false, "error": "Old password is required"})
+			return
+		}
 		// 新的密码
 		newPassword := r.Get("newPassword").String()
 		if newPassword == "" {
 			r.Response.WriteJsonExit(g.Map{"success": false, "error": "New password is required"})
 			return
 		}
-		service.ResetPassword(r)
+		service.ChangePassword(r)
 	})
 
 	s.Run()
+
+	// 服务停止后关闭 LDAP 连接池
+	service.CloseLDAPService()
 }
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/net/gtrace"
+	"github.com/gogf/gf/v2/os/gctx"
+	"github.com/gogf/gf/v2/os/glog"
+)
+
+// 审计事件类型
+const (
+	auditLookup          = "lookup"           // 查找用户
+	auditCaptchaIssued   = "captcha_issued"   // 签发图形验证码
+	auditCaptchaFailed   = "captcha_failed"   // 图形验证码校验失败
+	auditCodeSent        = "code_sent"        // 发送验证码
+	auditCodeVerified    = "code_verified"    // 校验验证码
+	auditPasswordReset   = "password_reset"   // 重置密码
+	auditPasswordChange  = "password_change"  // 凭当前密码修改密码
+	auditAccountLocked   = "lockout"          // 查找到的账户处于锁定状态
+	auditAccountUnlock   = "unlock"           // 解锁账户
+	auditTOTPEnroll      = "totp_enroll"      // 绑定 TOTP
+	auditQuestionsEnroll = "questions_enroll" // 设置安全问题
+)
+
+// 审计结果
+const (
+	auditSuccess  = "success"
+	auditFailure  = "failure"
+	auditDetected = "detected" // 仅记录状态，例如查找到已锁定的账户
+)
+
+// AuditEvent 审计事件，以 JSON 形式写入审计日志
+type AuditEvent struct {
+	Time        string `json:"time"`
+	Event       string `json:"event"`
+	Outcome     string `json:"outcome"`
+	Username    string `json:"username,omitempty"`    // 请求中的用户名
+	UserDN      string `json:"userDN,omitempty"`      // 目标用户 DN
+	Channel     string `json:"channel,omitempty"`     // 验证方式
+	Destination string `json:"destination,omitempty"` // 打码后的接收方
+	Reason      string `json:"reason,omitempty"`      // 失败原因
+	ClientIP    string `json:"clientIP"`
+	UserAgent   string `json:"userAgent"`
+	RequestID   string `json:"requestID"`
+}
+
+var (
+	auditOnce    sync.Once
+	auditEnabled bool
+	auditLogger  *glog.Logger
+	auditSyslog  *syslogWriter
+)
+
+// 初始化审计日志：独立的滚动文件，可选同时发送到 syslog
+func initAudit() {
+	auditOnce.Do(func() {
+		ctx := context.TODO()
+		auditEnabled = g.Cfg().MustGet(ctx, "audit.enabled", true).Bool()
+		if !auditEnabled {
+			return
+		}
+
+		auditLogger = glog.New()
+		err := auditLogger.SetConfigWithMap(g.Map{
+			"path":              g.Cfg().MustGet(ctx, "audit.path", "./log/audit").String(),
+			"file":              g.Cfg().MustGet(ctx, "audit.file", "audit-{Y-m-d}.log").String(),
+			"rotateSize":        g.Cfg().MustGet(ctx, "audit.rotateSize", "100M").String(),
+			"rotateBackupLimit": g.Cfg().MustGet(ctx, "audit.rotateBackupLimit", 30).Int(),
+			"header":            false, // 每行只输出 JSON
+			"stdout":            false,
+		})
+		if err != nil {
+			g.Log().Error(gctx.New(), "failed to configure audit logger:", err)
+		}
+
+		if g.Cfg().MustGet(ctx, "audit.syslog.enabled", false).Bool() {
+			auditSyslog = newSyslogWriter(
+				g.Cfg().MustGet(ctx, "audit.syslog.network", "udp").String(),
+				g.Cfg().MustGet(ctx, "audit.syslog.address", "127.0.0.1:514").String(),
+				g.Cfg().MustGet(ctx, "audit.syslog.facility", 10).Int(), // 默认 authpriv
+				g.Cfg().MustGet(ctx, "audit.syslog.appName", "ldap-password-reset").String(),
+				g.Cfg().MustGet(ctx, "audit.syslog.queueSize", syslogQueueSize).Int(),
+			)
+		}
+	})
+}
+
+// 请求 ID，优先使用网关传入的 X-Request-ID
+func requestID(r *ghttp.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		return id
+	}
+	return gtrace.GetTraceID(r.Context())
+}
+
+// 按验证方式对接收方打码
+func maskDestination(channel string, user *LDAPUser) string {
+	if user == nil {
+		return ""
+	}
+	switch channel {
+	case "mail":
+		return maskMail(user.Mail)
+	case "mobile":
+		return maskMobile(user.Mobile)
+	}
+	return ""
+}
+
+// audit 记录一条审计事件，user 可以为空
+func audit(r *ghttp.Request, event, outcome string, user *LDAPUser, channel, reason string) {
+	initAudit()
+	if !auditEnabled {
+		return
+	}
+
+	e := AuditEvent{
+		Time:        time.Now().Format(time.RFC3339Nano),
+		Event:       event,
+		Outcome:     outcome,
+		Username:    r.Get("username").String(),
+		Channel:     channel,
+		Destination: maskDestination(channel, user),
+		Reason:      reason,
+		ClientIP:    clientIP(r),
+		UserAgent:   r.UserAgent(),
+		RequestID:   requestID(r),
+	}
+	if user != nil {
+		e.UserDN = user.DN
+		// 隐私模式下的假用户在审计日志中如实记录为失败
+		if user.fake {
+			e.Outcome = auditFailure
+			e.Destination = ""
+			if e.Reason == "" {
+				e.Reason = "user not found"
+			}
+		}
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		g.Log().Error(gctx.New(), "failed to marshal audit event:", err)
+		return
+	}
+	// 不传入请求上下文，避免 glog 在行首追加链路 ID，保证每行都是合法 JSON
+	auditLogger.Print(context.Background(), string(data))
+
+	if auditSyslog != nil {
+		severity := syslogSeverityInfo
+		if outcome == auditFailure {
+			severity = syslogSeverityWarning
+		}
+		// 异步发送，只有队列已满时才会立即返回错误
+		if err := auditSyslog.Send(severity, event, string(data)); err != nil {
+			g.Log().Error(gctx.New(), "failed to send audit event to syslog:", err)
+		}
+	}
+}
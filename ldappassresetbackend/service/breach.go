@@ -0,0 +1,174 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gctx"
+)
+
+// ErrBreachedPassword 密码存在于泄露密码库中
+var ErrBreachedPassword = errors.New("password found in breached password list")
+
+// 泄露密码库格式
+const (
+	breachFormatHIBP  = "hibp"  // HIBP 下载格式：每行 SHA1:次数，或以 5 位前缀命名的分段文件，每行 后缀:次数
+	breachFormatPlain = "plain" // 明文字典：每行一个密码
+)
+
+// bloomFilter 布隆过滤器，仅保存 SHA-1 摘要映射后的位，内存占用远小于原始列表
+type bloomFilter struct {
+	bits []uint64
+	m    uint64 // 位数
+	k    uint64 // 哈希函数个数
+}
+
+func newBloomFilter(n int, falsePositiveRate float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+// SHA-1 摘要本身分布均匀，直接取前 16 字节做双重哈希
+func (b *bloomFilter) positions(digest []byte, fn func(uint64)) {
+	h1 := binary.BigEndian.Uint64(digest[0:8])
+	h2 := binary.BigEndian.Uint64(digest[8:16])
+	for i := uint64(0); i < b.k; i++ {
+		fn((h1 + i*h2) % b.m)
+	}
+}
+
+func (b *bloomFilter) add(digest []byte) {
+	b.positions(digest, func(pos uint64) {
+		b.bits[pos/64] |= 1 << (pos % 64)
+	})
+}
+
+func (b *bloomFilter) contains(digest []byte) bool {
+	found := true
+	b.positions(digest, func(pos uint64) {
+		if b.bits[pos/64]&(1<<(pos%64)) == 0 {
+			found = false
+		}
+	})
+	return found
+}
+
+var breachedPasswords *bloomFilter
+
+// LoadBreachedPasswords 启动时加载泄露密码库，未启用时直接返回
+func LoadBreachedPasswords() error {
+	ctx := context.TODO()
+	if !g.Cfg().MustGet(ctx, "breachedPasswords.enabled", false).Bool() {
+		return nil
+	}
+	path := g.Cfg().MustGet(ctx, "breachedPasswords.path").String()
+	format := g.Cfg().MustGet(ctx, "breachedPasswords.format", breachFormatHIBP).String()
+	rate := g.Cfg().MustGet(ctx, "breachedPasswords.falsePositiveRate", 0.001).Float64()
+	if rate <= 0 || rate >= 1 {
+		return fmt.Errorf("invalid falsePositiveRate %v", rate)
+	}
+
+	files, err := breachFiles(path)
+	if err != nil {
+		return err
+	}
+
+	// 第一遍统计条目数，用于计算过滤器大小
+	total := 0
+	for _, file := range files {
+		if err := eachBreachDigest(file, format, func([]byte) { total++ }); err != nil {
+			return err
+		}
+	}
+
+	filter := newBloomFilter(total, rate)
+	for _, file := range files {
+		if err := eachBreachDigest(file, format, filter.add); err != nil {
+			return err
+		}
+	}
+	breachedPasswords = filter
+	g.Log().Infof(gctx.New(), "loaded %d breached password hashes", total)
+	return nil
+}
+
+// 路径可以是单个文件，也可以是 HIBP 分段文件所在目录
+func breachFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	return filepath.Glob(filepath.Join(path, "*.txt"))
+}
+
+// 逐行读取文件，将每个条目转换为 SHA-1 摘要
+func eachBreachDigest(file, format string, fn func([]byte)) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// 分段文件以 5 位十六进制前缀命名，行内只有后缀
+	prefix := ""
+	if base := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)); len(base) == 5 {
+		if _, err := hex.DecodeString(base + "0"); err == nil {
+			prefix = base
+		}
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		switch format {
+		case breachFormatPlain:
+			sum := sha1.Sum([]byte(line))
+			fn(sum[:])
+		case breachFormatHIBP:
+			hash, _, _ := strings.Cut(line, ":")
+			digest, err := hex.DecodeString(prefix + hash)
+			if err != nil || len(digest) != sha1.Size {
+				continue
+			}
+			fn(digest)
+		default:
+			return fmt.Errorf("unknown breached password format %q", format)
+		}
+	}
+	return scanner.Err()
+}
+
+// 检查密码是否存在于泄露密码库
+func checkBreachedPassword(password string) error {
+	if breachedPasswords == nil {
+		return nil
+	}
+	sum := sha1.Sum([]byte(password))
+	if breachedPasswords.contains(sum[:]) {
+		return ErrBreachedPassword
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	const n = 10000
+	filter := newBloomFilter(n, 0.001)
+	for i := 0; i < n; i++ {
+		sum := sha1.Sum([]byte(fmt.Sprintf("known-%d", i)))
+		filter.add(sum[:])
+	}
+
+	// 已加入的条目不能漏报
+	for i := 0; i < n; i++ {
+		sum := sha1.Sum([]byte(fmt.Sprintf("known-%d", i)))
+		if !filter.contains(sum[:]) {
+			t.Fatalf("false negative for known-%d", i)
+		}
+	}
+
+	// 误报率应接近设定值，留出足够余量避免偶发失败
+	falsePositives := 0
+	for i := 0; i < n; i++ {
+		sum := sha1.Sum([]byte(fmt.Sprintf("unknown-%d", i)))
+		if filter.contains(sum[:]) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / n; rate > 0.005 {
+		t.Errorf("false positive rate = %.4f, want about 0.001", rate)
+	}
+}
+
+// 写入泄露密码库并加载，测试结束后清空
+func loadTestBreach(t *testing.T, path, format string) error {
+	t.Helper()
+	cfg, _ := json.Marshal(map[string]any{
+		"breachedPasswords": map[string]any{"enabled": true, "path": path, "format": format},
+	})
+	setTestConfig(t, string(cfg))
+	breachedPasswords = nil
+	t.Cleanup(func() { breachedPasswords = nil })
+	return LoadBreachedPasswords()
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(fmt.Sprintf("%x", sum))
+}
+
+func writeTestFile(t *testing.T, path string, lines []string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var testBreached = []string{"123456", "password", "qwerty123", "P@ssw0rd", "密码123456"}
+
+// 检查已知条目全部命中，未收录的密码通过
+func checkTestBreach(t *testing.T) {
+	t.Helper()
+	for _, pw := range testBreached {
+		if err := checkBreachedPassword(pw); err != ErrBreachedPassword {
+			t.Errorf("checkBreachedPassword(%q) = %v, want ErrBreachedPassword", pw, err)
+		}
+	}
+	for _, pw := range []string{"correct horse battery staple", "Password", "123457"} {
+		if err := checkBreachedPassword(pw); err != nil {
+			t.Errorf("checkBreachedPassword(%q) = %v, want nil", pw, err)
+		}
+	}
+}
+
+func TestBreachedPasswordsHIBPFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pwned-passwords-sha1.txt")
+	var lines []string
+	for i, pw := range testBreached {
+		lines = append(lines, fmt.Sprintf("%s:%d", sha1Hex(pw), i+1))
+	}
+	// 无效行跳过
+	lines = append(lines, "", "not-a-hash:1", "ABCDEF:2")
+	writeTestFile(t, path, lines)
+
+	if err := loadTestBreach(t, path, breachFormatHIBP); err != nil {
+		t.Fatal(err)
+	}
+	checkTestBreach(t)
+}
+
+func TestBreachedPasswordsHIBPPrefixFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := make(map[string][]string)
+	for i, pw := range testBreached {
+		hash := sha1Hex(pw)
+		files[hash[:5]] = append(files[hash[:5]], fmt.Sprintf("%s:%d", hash[5:], i+1))
+	}
+	for prefix, lines := range files {
+		// 文件名大小写均可
+		writeTestFile(t, filepath.Join(dir, strings.ToLower(prefix)+".txt"), lines)
+	}
+
+	if err := loadTestBreach(t, dir, breachFormatHIBP); err != nil {
+		t.Fatal(err)
+	}
+	checkTestBreach(t)
+}
+
+func TestBreachedPasswordsPlain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "common.txt")
+	// 行首尾空白忽略
+	lines := append([]string{""}, testBreached...)
+	lines[1] = "  " + lines[1] + "\t"
+	writeTestFile(t, path, lines)
+
+	if err := loadTestBreach(t, path, breachFormatPlain); err != nil {
+		t.Fatal(err)
+	}
+	checkTestBreach(t)
+}
+
+func TestBreachedPasswordsErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.txt")
+	writeTestFile(t, path, []string{"123456"})
+	if err := loadTestBreach(t, path, "csv"); err == nil {
+		t.Error("unknown format accepted")
+	}
+	if err := loadTestBreach(t, filepath.Join(t.TempDir(), "missing.txt"), breachFormatPlain); err == nil {
+		t.Error("missing file accepted")
+	}
+	// 加载失败时不启用检查
+	if err := checkBreachedPassword("123456"); err != nil {
+		t.Errorf("checkBreachedPassword after failed load = %v", err)
+	}
+}
@@ -1,63 +1,67 @@
 package service
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
-	"os"
-	"path/filepath"
+	"net/http"
 	"strconv"
-	"sync"
 	"time"
 
 	"github.com/dchest/captcha"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/os/gctx"
 )
 
-var captchaStore = struct {
-	sync.RWMutex
-	store map[string]captchaData
-}{store: make(map[string]captchaData)}
-
-type captchaData struct {
-	answer  string
-	created time.Time
-}
-
 const captchaExpiryDuration = 5 * time.Minute
 
-func GenerateCaptcha(r *ghttp.Request) {
-	id := captcha.New()
-
-	captchaPath := filepath.Join("images", id+".png")
-
-	answer := captcha.RandomDigits(6)
+// 图形验证码尺寸
+const (
+	captchaWidth  = 240
+	captchaHeight = 80
+)
 
-	var result string
-	for _, b := range answer {
-		result += strconv.Itoa(int(b))
+// 图片按需从答案在内存中渲染，同一 ID 与答案始终得到相同的图片，不写入磁盘
+func renderCaptcha(id, answer string) ([]byte, error) {
+	digits := make([]byte, len(answer))
+	for i, c := range answer {
+		if c < '0' || c > '9' {
+			return nil, fmt.Errorf("invalid captcha answer")
+		}
+		digits[i] = byte(c - '0')
 	}
-
-	captchaStore.Lock()
-	captchaStore.store[id] = captchaData{
-		answer:  result,
-		created: time.Now(),
+	var buf bytes.Buffer
+	if _, err := captcha.NewImage(id, digits, captchaWidth, captchaHeight).WriteTo(&buf); err != nil {
+		return nil, err
 	}
-	captchaStore.Unlock()
-
-	img := captcha.NewImage(id, answer, 240, 80)
+	return buf.Bytes(), nil
+}
 
-	file, err := os.Create(captchaPath)
-	if err != nil {
+func GenerateCaptcha(r *ghttp.Request) {
+	// 不使用 captcha.New，避免答案同时保存在该库的全局存储中
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		audit(r, auditCaptchaIssued, auditFailure, nil, "", err.Error())
 		r.Response.WriteJsonExit(g.Map{
 			"code":    10001,
 			"message": "Failed to generate verification code.",
 		})
 		return
 	}
-	defer file.Close()
+	id := hex.EncodeToString(raw)
 
-	_, err = img.WriteTo(file)
-	if err != nil {
+	answer := captcha.RandomDigits(6)
+
+	var result string
+	for _, b := range answer {
+		result += strconv.Itoa(int(b))
+	}
+
+	if err := getCaptchaStore().Set(id, result); err != nil {
+		audit(r, auditCaptchaIssued, auditFailure, nil, "", err.Error())
 		r.Response.WriteJsonExit(g.Map{
 			"code":    10001,
 			"message": "Failed to generate verification code.",
@@ -65,51 +69,82 @@ func GenerateCaptcha(r *ghttp.Request) {
 		return
 	}
 
-	r.Response.WriteJson(g.Map{
+	resp := g.Map{
 		"code": 200,
 		"id":   id,
-		"url":  "/captcha/" + id + ".png",
-	})
+		"url":  "/api/captcha/" + id + ".png",
+	}
+	// 可选直接返回 data URI，省去一次图片请求
+	if g.Cfg().MustGet(r.Context(), "captcha.dataURI", false).Bool() {
+		img, err := renderCaptcha(id, result)
+		if err != nil {
+			audit(r, auditCaptchaIssued, auditFailure, nil, "", err.Error())
+			r.Response.WriteJsonExit(g.Map{
+				"code":    10001,
+				"message": "Failed to generate verification code.",
+			})
+			return
+		}
+		resp["image"] = "data:image/png;base64," + base64.StdEncoding.EncodeToString(img)
+	}
+
+	audit(r, auditCaptchaIssued, auditSuccess, nil, "", "")
+	r.Response.WriteJson(resp)
+}
+
+// CaptchaImage 输出图形验证码图片，验证码过期或已使用时返回 404
+func CaptchaImage(r *ghttp.Request) {
+	id := r.Get("id").String()
+	answer, exists, err := getCaptchaStore().Get(id)
+	if err != nil {
+		g.Log().Error(gctx.New(), "Error reading captcha:", err)
+		r.Response.WriteStatusExit(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		r.Response.WriteStatusExit(http.StatusNotFound)
+		return
+	}
+	img, err := renderCaptcha(id, answer)
+	if err != nil {
+		g.Log().Error(gctx.New(), "Error rendering captcha:", err)
+		r.Response.WriteStatusExit(http.StatusInternalServerError)
+		return
+	}
+	r.Response.Header().Set("Content-Type", "image/png")
+	r.Response.Header().Set("Cache-Control", "no-store")
+	r.Response.Write(img)
 }
 
 func VerifyCaptcha(r *ghttp.Request) bool {
 	id := r.Get("verifyID").String()
 	answer := r.Get("verifyCode").String()
 
-	captchaStore.RLock()
-	captchaData, exists := captchaStore.store[id]
-	captchaStore.RUnlock()
-
-	if !exists {
-		// 没有此验证码
+	storedAnswer, exists, err := getCaptchaStore().Get(id)
+	if err != nil {
+		g.Log().Error(gctx.New(), "Error reading captcha:", err)
 		return false
 	}
 
-	if time.Since(captchaData.created) > captchaExpiryDuration {
+	if !exists {
+		// 没有此验证码或验证码超时
 		DelectVerify(id)
-		// 验证码超时
+		audit(r, auditCaptchaFailed, auditFailure, nil, "", "captcha not found or expired")
 		return false
 	}
 
-	if answer == captchaData.answer {
-		DelectVerify(id)
-		// 验证成功
-		return true
-	} else {
-		DelectVerify(id)
-		// 无效验证码
+	// 验证码只能使用一次
+	DelectVerify(id)
+	if answer != storedAnswer {
+		audit(r, auditCaptchaFailed, auditFailure, nil, "", "wrong answer")
 		return false
 	}
+	return true
 }
 
 func DelectVerify(id string) {
-	// 删除文件
-	captchaPath := filepath.Join("images", id+".png")
-	if err := os.Remove(captchaPath); err != nil {
-		fmt.Println("Error deleting captcha image:", err)
-	}
 	// 删除验证码
-	captchaStore.Lock()
-	delete(captchaStore.store, id)
-	captchaStore.Unlock()
+	if err := getCaptchaStore().Delete(id); err != nil {
+		g.Log().Error(gctx.New(), "Error deleting captcha:", err)
+	}
 }
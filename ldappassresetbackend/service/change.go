@@ -0,0 +1,100 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// ChangePassword 用户凭当前密码自助修改密码，无需短信或邮箱验证码
+func ChangePassword(r *ghttp.Request) {
+	defer padResponseTime(time.Now())
+
+	// 校验图形验证码，防止暴力猜测当前密码
+	if !VerifyCaptcha(r) {
+		r.Response.WriteJsonExit(g.Map{
+			"code":    10006,
+			"message": "Invalid code",
+		})
+		return
+	}
+
+	username := r.Get("username").String()
+	ldapService, err := GetLDAPService()
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 10007, "message": err.Error()})
+		return
+	}
+
+	user, err := lookupUser(ldapService, username)
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 10008, "message": err.Error()})
+		return
+	}
+	// 隐私模式下不存在的用户按当前密码错误处理
+	if user.fake {
+		audit(r, auditPasswordChange, auditFailure, user, "", "")
+		r.Response.WriteJsonExit(g.Map{
+			"code":    10016,
+			"message": ErrInvalidCredentials.Error(),
+		})
+	}
+
+	// 解密当前密码和新密码
+	oldPassword, err := DecryptPassword(r.Get("oldPassword").String())
+	if err != nil {
+		audit(r, auditPasswordChange, auditFailure, user, "", "failed to decrypt password")
+		r.Response.WriteJsonExit(g.Map{
+			"code":    10002,
+			"message": "Failed to decrypt password",
+		})
+		return
+	}
+	newPassword, err := DecryptPassword(r.Get("newPassword").String())
+	if err != nil {
+		audit(r, auditPasswordChange, auditFailure, user, "", "failed to decrypt password")
+		r.Response.WriteJsonExit(g.Map{
+			"code":    10002,
+			"message": "Failed to decrypt password",
+		})
+		return
+	}
+	// 二次校验密码
+	if err := validatePassword(newPassword, user); err != nil {
+		audit(r, auditPasswordChange, auditFailure, user, "", err.Error())
+		if errors.Is(err, ErrBreachedPassword) {
+			r.Response.WriteJsonExit(g.Map{
+				"code":    10017,
+				"message": err.Error(),
+			})
+		}
+		r.Response.WriteJsonExit(g.Map{
+			"code":    10003,
+			"message": err.Error(),
+		})
+		return
+	}
+	// 以用户身份修改密码
+	if err := ldapService.ChangePassword(username, oldPassword, newPassword); err != nil {
+		audit(r, auditPasswordChange, auditFailure, user, "", err.Error())
+		if errors.Is(err, ErrInvalidCredentials) {
+			r.Response.WriteJsonExit(g.Map{
+				"code":    10016,
+				"message": err.Error(),
+			})
+		}
+		r.Response.WriteJsonExit(g.Map{
+			"code":    10004,
+			"message": err.Error(),
+		})
+		return
+	}
+	audit(r, auditPasswordChange, auditSuccess, user, "", "")
+	notifyPasswordChanged(r, user, "修改密码")
+	r.Response.WriteJsonExit(g.Map{
+		"code":    200,
+		"message": "Success",
+	})
+}
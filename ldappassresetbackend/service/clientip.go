@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/os/gctx"
+)
+
+var (
+	trustedProxiesOnce sync.Once
+	trustedProxies     []*net.IPNet
+)
+
+// 加载可信代理列表，支持单个 IP 或 CIDR，未配置时不信任任何 X-Forwarded-For
+func loadTrustedProxies() {
+	trustedProxiesOnce.Do(func() {
+		for _, v := range g.Cfg().MustGet(context.TODO(), "server.trustedProxies").Strings() {
+			v = strings.TrimSpace(v)
+			if !strings.Contains(v, "/") {
+				if ip := net.ParseIP(v); ip != nil && ip.To4() != nil {
+					v += "/32"
+				} else {
+					v += "/128"
+				}
+			}
+			_, ipNet, err := net.ParseCIDR(v)
+			if err != nil {
+				g.Log().Warningf(gctx.New(), "invalid trusted proxy %q: %v", v, err)
+				continue
+			}
+			trustedProxies = append(trustedProxies, ipNet)
+		}
+	})
+}
+
+// clientIP 获取客户端 IP，仅当直连方是可信代理时才采信 X-Forwarded-For，
+// 防止客户端伪造请求头绕过按 IP 限流或篡改审计记录
+func clientIP(r *ghttp.Request) string {
+	loadTrustedProxies()
+	return resolveClientIP(r.GetRemoteIp(), r.Header.Get("X-Forwarded-For"), trustedProxies)
+}
+
+// 从右向左跳过可信代理，第一个不可信的地址即为客户端
+func resolveClientIP(remote, forwardedFor string, trusted []*net.IPNet) string {
+	if !isTrustedProxy(remote, trusted) || forwardedFor == "" {
+		return remote
+	}
+	hops := strings.Split(forwardedFor, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			// 无法解析的地址不可信，退回到最后一个可信代理
+			return remote
+		}
+		if !isTrustedProxy(hop, trusted) {
+			return hop
+		}
+		remote = hop
+	}
+	return remote
+}
+
+func isTrustedProxy(ip string, trusted []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range trusted {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
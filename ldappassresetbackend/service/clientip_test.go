@@ -0,0 +1,45 @@
+package service
+
+import (
+	"net"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	var trusted []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "192.168.1.1/32"} {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trusted = append(trusted, ipNet)
+	}
+
+	tests := []struct {
+		name         string
+		remote       string
+		forwardedFor string
+		want         string
+	}{
+		{"direct", "203.0.113.7", "", "203.0.113.7"},
+		{"spoofed header from untrusted peer", "203.0.113.7", "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.5", "198.51.100.1", "198.51.100.1"},
+		{"client prepends fake hop", "10.0.0.5", "1.2.3.4, 198.51.100.1", "198.51.100.1"},
+		{"proxy chain", "10.0.0.5", "198.51.100.1, 192.168.1.1", "198.51.100.1"},
+		{"only proxies", "10.0.0.5", "10.0.0.6", "10.0.0.6"},
+		{"garbage hop", "10.0.0.5", "not-an-ip", "10.0.0.5"},
+		{"trusted proxy without header", "10.0.0.5", "", "10.0.0.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveClientIP(tt.remote, tt.forwardedFor, trusted); got != tt.want {
+				t.Errorf("resolveClientIP(%q, %q) = %q, want %q", tt.remote, tt.forwardedFor, got, tt.want)
+			}
+		})
+	}
+
+	// 未配置可信代理时始终使用连接地址
+	if got := resolveClientIP("203.0.113.7", "198.51.100.1", nil); got != "203.0.113.7" {
+		t.Errorf("resolveClientIP without trusted proxies = %q", got)
+	}
+}
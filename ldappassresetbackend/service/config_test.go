@@ -0,0 +1,20 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gcfg"
+)
+
+// 用给定的 JSON 内容替换配置，测试结束后恢复
+func setTestConfig(t *testing.T, content string) {
+	t.Helper()
+	adapter, err := gcfg.NewAdapterContent(content)
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	prev := g.Cfg().GetAdapter()
+	g.Cfg().SetAdapter(adapter)
+	t.Cleanup(func() { g.Cfg().SetAdapter(prev) })
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/os/gctx"
+)
+
+// 拒绝规则：受保护的账户(管理员组、指定 OU、adminCount=1 等)不允许自助重置，需要联系服务台
+
+// denyAttributeRule 属性取值命中 values 中任一值(忽略大小写)时拒绝，values 为空时只要属性存在即拒绝
+type denyAttributeRule struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+var (
+	denyOnce       sync.Once
+	denyEnabled    bool
+	denyGroups     []string
+	denyOUs        []*ldap.DN
+	denyAdminCount bool
+	denyAttributes []denyAttributeRule
+	denyHelpdesk   string
+	denyErr        error
+)
+
+func loadDenyConfig() error {
+	denyOnce.Do(func() {
+		ctx := context.TODO()
+		denyEnabled = g.Cfg().MustGet(ctx, "denyRules.enabled", false).Bool()
+		if !denyEnabled {
+			return
+		}
+		denyGroups = g.Cfg().MustGet(ctx, "denyRules.groups").Strings()
+		for _, ou := range g.Cfg().MustGet(ctx, "denyRules.ous").Strings() {
+			dn, err := ldap.ParseDN(ou)
+			if err != nil {
+				denyErr = fmt.Errorf("invalid denyRules.ous %q: %v", ou, err)
+				return
+			}
+			denyOUs = append(denyOUs, dn)
+		}
+		denyAdminCount = g.Cfg().MustGet(ctx, "denyRules.adminCount", true).Bool()
+		if err := g.Cfg().MustGet(ctx, "denyRules.attributes").Scan(&denyAttributes); err != nil {
+			denyErr = fmt.Errorf("invalid denyRules.attributes: %v", err)
+			return
+		}
+		denyHelpdesk = g.Cfg().MustGet(ctx, "denyRules.helpdesk",
+			g.Cfg().MustGet(ctx, "notify.helpdesk", "IT 服务台").String()).String()
+	})
+	return denyErr
+}
+
+// GetUser 需要额外读取的属性
+func denyRuleAttributes() []string {
+	if err := loadDenyConfig(); err != nil || !denyEnabled {
+		return nil
+	}
+	var attributes []string
+	if denyAdminCount {
+		attributes = append(attributes, "adminCount")
+	}
+	for _, rule := range denyAttributes {
+		attributes = append(attributes, rule.Name)
+	}
+	return attributes
+}
+
+// denyReason 返回用户命中的拒绝规则，未命中时返回空字符串
+func denyReason(user *LDAPUser) string {
+	if user.fake {
+		return ""
+	}
+	if err := loadDenyConfig(); err != nil {
+		// 配置错误时无法判断账户是否受保护，一律拒绝
+		g.Log().Error(gctx.New(), "invalid denyRules config:", err)
+		return "invalid deny rules"
+	}
+	if !denyEnabled {
+		return ""
+	}
+	for _, group := range denyGroups {
+		if user.memberOf(group) {
+			return "member of " + group
+		}
+	}
+	if len(denyOUs) > 0 {
+		dn, err := ldap.ParseDN(user.DN)
+		if err != nil {
+			return "unparsable dn"
+		}
+		for _, ou := range denyOUs {
+			if ou.AncestorOfFold(dn) {
+				return "under " + ou.String()
+			}
+		}
+	}
+	if denyAdminCount && user.attribute("adminCount") == "1" {
+		return "adminCount=1"
+	}
+	for _, rule := range denyAttributes {
+		values := user.attributes[strings.ToLower(rule.Name)]
+		if len(values) == 0 {
+			continue
+		}
+		if len(rule.Values) == 0 {
+			return rule.Name + " present"
+		}
+		for _, v := range values {
+			for _, denied := range rule.Values {
+				if strings.EqualFold(v, denied) {
+					return rule.Name + "=" + v
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// checkProtected 受保护的账户返回专用状态码，引导用户联系服务台
+// 隐私模式下改为按不存在的用户继续处理，避免通过状态码枚举特权账户
+func checkProtected(r *ghttp.Request, event string, user *LDAPUser) *LDAPUser {
+	reason := denyReason(user)
+	if reason == "" {
+		return user
+	}
+	audit(r, event, auditFailure, user, r.Get("type").String(), "protected account: "+reason)
+	if isPrivacyMode() {
+		return fakeUser(r.Get("username").String())
+	}
+	r.Response.WriteJsonExit(g.Map{
+		"code":     10028,
+		"message":  "Self-service reset is not allowed for this account",
+		"helpdesk": denyHelpdesk,
+	})
+	return nil
+}
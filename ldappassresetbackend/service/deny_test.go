@@ -0,0 +1,105 @@
+package service
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 启用拒绝规则：管理员组、服务账户 OU、adminCount 及 employeeType、lockedBy 属性
+func setupDenyRules(t *testing.T) {
+	t.Helper()
+	setTestConfig(t, `{
+		"denyRules": {
+			"enabled": true,
+			"groups": ["CN=Domain Admins,CN=Users,DC=example,DC=com"],
+			"ous": ["OU=Service Accounts,DC=example,DC=com"],
+			"adminCount": true,
+			"attributes": [{"name": "employeeType", "values": ["service", "shared"]}, {"name": "lockedBy"}]
+		}
+	}`)
+	resetDenyRules()
+	t.Cleanup(resetDenyRules)
+}
+
+func resetDenyRules() {
+	denyOnce = sync.Once{}
+	denyEnabled, denyGroups, denyOUs, denyAdminCount, denyAttributes, denyErr = false, nil, nil, false, nil, nil
+}
+
+func TestDenyReason(t *testing.T) {
+	setupDenyRules(t)
+
+	tests := []struct {
+		name string
+		user *LDAPUser
+		want string // 期望命中的原因前缀，空字符串表示放行
+	}{
+		{"regular user", &LDAPUser{DN: "CN=Alice,OU=Staff,DC=example,DC=com"}, ""},
+		{"admin group", &LDAPUser{
+			DN:     "CN=Bob,OU=Staff,DC=example,DC=com",
+			Groups: []string{"cn=domain admins,cn=users,dc=example,dc=com"},
+		}, "member of"},
+		{"other group", &LDAPUser{
+			DN:     "CN=Bob,OU=Staff,DC=example,DC=com",
+			Groups: []string{"CN=Domain Users,CN=Users,DC=example,DC=com"},
+		}, ""},
+		{"ou", &LDAPUser{DN: "CN=svc-backup,OU=Service Accounts,DC=example,DC=com"}, "under"},
+		{"nested ou", &LDAPUser{DN: "CN=svc-web,OU=Web,ou=service accounts,dc=example,dc=com"}, "under"},
+		{"similar ou", &LDAPUser{DN: "CN=carol,OU=Service Accounts Old,DC=example,DC=com"}, ""},
+		{"adminCount", &LDAPUser{
+			DN:         "CN=Dave,OU=Staff,DC=example,DC=com",
+			attributes: map[string][]string{"admincount": {"1"}},
+		}, "adminCount=1"},
+		{"adminCount cleared", &LDAPUser{
+			DN:         "CN=Dave,OU=Staff,DC=example,DC=com",
+			attributes: map[string][]string{"admincount": {"0"}},
+		}, ""},
+		{"attribute value", &LDAPUser{
+			DN:         "CN=Erin,OU=Staff,DC=example,DC=com",
+			attributes: map[string][]string{"employeetype": {"Contractor", "SHARED"}},
+		}, "employeeType=SHARED"},
+		{"attribute other value", &LDAPUser{
+			DN:         "CN=Erin,OU=Staff,DC=example,DC=com",
+			attributes: map[string][]string{"employeetype": {"Contractor"}},
+		}, ""},
+		{"attribute present", &LDAPUser{
+			DN:         "CN=Frank,OU=Staff,DC=example,DC=com",
+			attributes: map[string][]string{"lockedby": {"helpdesk"}},
+		}, "lockedBy present"},
+		{"fake user", fakeUser("CN=svc,OU=Service Accounts,DC=example,DC=com"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := denyReason(tt.user)
+			if tt.want == "" && got != "" || !strings.HasPrefix(got, tt.want) {
+				t.Errorf("denyReason = %q, want prefix %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDenyRulesDisabled(t *testing.T) {
+	setTestConfig(t, `{"denyRules": {"enabled": false, "groups": ["CN=Domain Admins,CN=Users,DC=example,DC=com"]}}`)
+	resetDenyRules()
+	t.Cleanup(resetDenyRules)
+
+	user := &LDAPUser{DN: "CN=Bob,DC=example,DC=com", Groups: []string{"CN=Domain Admins,CN=Users,DC=example,DC=com"}}
+	if got := denyReason(user); got != "" {
+		t.Errorf("denyReason = %q with rules disabled", got)
+	}
+	if attrs := denyRuleAttributes(); attrs != nil {
+		t.Errorf("denyRuleAttributes = %v with rules disabled", attrs)
+	}
+}
+
+func TestDenyRulesInvalidConfig(t *testing.T) {
+	setTestConfig(t, `{"denyRules": {"enabled": true, "ous": ["not a dn"]}}`)
+	resetDenyRules()
+	t.Cleanup(resetDenyRules)
+
+	// 配置无法解析时一律拒绝
+	if got := denyReason(&LDAPUser{DN: "CN=Alice,DC=example,DC=com"}); got == "" {
+		t.Error("denyReason allowed user with invalid config")
+	}
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// 钉钉渠道：以工作通知的形式把验证码发送到用户的钉钉
+
+type dingTalkService struct {
+	endpoint        string
+	appKey          string
+	appSecret       string
+	agentID         int64
+	resolveByMobile bool // 目录中未配置钉钉账号属性时，按手机号查找用户
+	message         *template.Template
+	tokens          *accessTokenCache
+}
+
+var (
+	dingTalkInstance *dingTalkService
+	dingTalkErr      error
+	dingTalkOnce     sync.Once
+)
+
+// 未启用钉钉时返回 nil
+func getDingTalkService() (*dingTalkService, error) {
+	dingTalkOnce.Do(func() {
+		ctx := context.TODO()
+		if !g.Cfg().MustGet(ctx, "dingtalk.enabled", false).Bool() {
+			return
+		}
+		message, err := template.New("dingtalk").Parse(
+			g.Cfg().MustGet(ctx, "dingtalk.message", "您的密码重置验证码为 {{.Code}}，5 分钟内有效，请勿泄露给他人。").String())
+		if err != nil {
+			dingTalkErr = fmt.Errorf("invalid dingtalk.message: %v", err)
+			return
+		}
+		s := &dingTalkService{
+			endpoint:        strings.TrimRight(g.Cfg().MustGet(ctx, "dingtalk.endpoint", "https://oapi.dingtalk.com").String(), "/"),
+			appKey:          g.Cfg().MustGet(ctx, "dingtalk.appKey").String(),
+			appSecret:       g.Cfg().MustGet(ctx, "dingtalk.appSecret").String(),
+			agentID:         g.Cfg().MustGet(ctx, "dingtalk.agentId").Int64(),
+			resolveByMobile: g.Cfg().MustGet(ctx, "dingtalk.resolveByMobile", true).Bool(),
+			message:         message,
+		}
+		s.tokens = newAccessTokenCache(s.fetchToken)
+		dingTalkInstance = s
+	})
+	return dingTalkInstance, dingTalkErr
+}
+
+// 用户是否可以使用钉钉渠道
+func dingTalkAvailable(user *LDAPUser) bool {
+	s, err := getDingTalkService()
+	if err != nil || s == nil {
+		return false
+	}
+	return user.DingTalk != "" || (s.resolveByMobile && user.Mobile != "")
+}
+
+// 钉钉渠道的验证码标识，加前缀与其他渠道区分
+func dingTalkIdentifier(user *LDAPUser) string {
+	if user.DingTalk != "" {
+		return "dingtalk:" + user.DingTalk
+	}
+	return "dingtalk:" + user.Mobile
+}
+
+func (s *dingTalkService) fetchToken() (string, time.Duration, error) {
+	var resp struct {
+		imResponse
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	u := s.endpoint + "/gettoken?appkey=" + url.QueryEscape(s.appKey) + "&appsecret=" + url.QueryEscape(s.appSecret)
+	if err := doIMRequest(u, nil, &resp); err != nil {
+		return "", 0, err
+	}
+	// 错误由 call 统一加上渠道前缀
+	if err := resp.err(); err != nil {
+		return "", 0, err
+	}
+	return resp.AccessToken, time.Duration(resp.ExpiresIn) * time.Second, nil
+}
+
+func (s *dingTalkService) call(path string, body interface{}, out imResult) error {
+	if err := callWithToken(s.tokens, s.endpoint+path, body, out); err != nil {
+		return fmt.Errorf("dingtalk: %v", err)
+	}
+	return nil
+}
+
+// 按手机号查找钉钉用户
+func (s *dingTalkService) userIDByMobile(mobile string) (string, error) {
+	var resp struct {
+		imResponse
+		Result struct {
+			UserID string `json:"userid"`
+		} `json:"result"`
+	}
+	if err := s.call("/topapi/v2/user/getbymobile", map[string]string{"mobile": mobile}, &resp); err != nil {
+		return "", err
+	}
+	return resp.Result.UserID, nil
+}
+
+// SendCode 以工作通知发送验证码
+func (s *dingTalkService) SendCode(user *LDAPUser, code string) error {
+	userID := user.DingTalk
+	if userID == "" {
+		if !s.resolveByMobile || user.Mobile == "" {
+			return fmt.Errorf("user has no dingtalk account")
+		}
+		var err error
+		if userID, err = s.userIDByMobile(user.Mobile); err != nil {
+			return err
+		}
+	}
+
+	var content bytes.Buffer
+	if err := s.message.Execute(&content, struct {
+		User string
+		Code string
+	}{
+		User: user.Name,
+		Code: code,
+	}); err != nil {
+		return err
+	}
+
+	// 工作通知为异步发送，接口成功只代表任务已创建
+	var resp struct {
+		imResponse
+		TaskID int64 `json:"task_id"`
+	}
+	return s.call("/topapi/message/corpconversation/asyncsend_v2", map[string]interface{}{
+		"agent_id":    s.agentID,
+		"userid_list": userID,
+		"msg": map[string]interface{}{
+			"msgtype": "text",
+			"text":    map[string]string{"content": content.String()},
+		},
+	}, &resp)
+}
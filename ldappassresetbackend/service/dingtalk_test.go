@@ -0,0 +1,222 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// 模拟钉钉开放平台，sendErrors 按顺序作为工作通知接口的 errcode 返回
+type fakeDingTalk struct {
+	mu          sync.Mutex
+	tokenCalls  int
+	tokenErr    int
+	mobileErr   int
+	sendErrors  []int
+	sendTokens  []string
+	sendBodies  []map[string]interface{}
+	mobileCalls []string
+}
+
+func (f *fakeDingTalk) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/gettoken":
+		if r.URL.Query().Get("appkey") != "key" || r.URL.Query().Get("appsecret") != "secret" {
+			http.Error(w, "bad credentials", http.StatusBadRequest)
+			return
+		}
+		if f.tokenErr != 0 {
+			fmt.Fprintf(w, `{"errcode":%d,"errmsg":"invalid appkey"}`, f.tokenErr)
+			return
+		}
+		f.tokenCalls++
+		fmt.Fprintf(w, `{"errcode":0,"errmsg":"ok","access_token":"token-%d","expires_in":7200}`, f.tokenCalls)
+	case "/topapi/v2/user/getbymobile":
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.mobileCalls = append(f.mobileCalls, body["mobile"])
+		if f.mobileErr != 0 {
+			fmt.Fprintf(w, `{"errcode":%d,"errmsg":"user not found"}`, f.mobileErr)
+			return
+		}
+		fmt.Fprintf(w, `{"errcode":0,"errmsg":"ok","result":{"userid":"uid-%s"}}`, body["mobile"])
+	case "/topapi/message/corpconversation/asyncsend_v2":
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.sendTokens = append(f.sendTokens, r.URL.Query().Get("access_token"))
+		f.sendBodies = append(f.sendBodies, body)
+		code := 0
+		if len(f.sendErrors) > 0 {
+			code, f.sendErrors = f.sendErrors[0], f.sendErrors[1:]
+		}
+		fmt.Fprintf(w, `{"errcode":%d,"errmsg":"msg","task_id":1}`, code)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestDingTalk(t *testing.T, resolveByMobile bool) (*dingTalkService, *fakeDingTalk) {
+	t.Helper()
+	fake := &fakeDingTalk{}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	s := &dingTalkService{
+		endpoint:        srv.URL,
+		appKey:          "key",
+		appSecret:       "secret",
+		agentID:         1001,
+		resolveByMobile: resolveByMobile,
+		message:         template.Must(template.New("dingtalk").Parse("{{.User}} 验证码 {{.Code}}")),
+	}
+	s.tokens = newAccessTokenCache(s.fetchToken)
+	return s, fake
+}
+
+func TestDingTalkSendCode(t *testing.T) {
+	s, fake := newTestDingTalk(t, true)
+	user := &LDAPUser{Name: "Alice", DingTalk: "alice01"}
+
+	if err := s.SendCode(user, "123456"); err != nil {
+		t.Fatalf("SendCode: %v", err)
+	}
+	body := fake.sendBodies[0]
+	if body["userid_list"] != "alice01" || body["agent_id"] != float64(1001) {
+		t.Errorf("body = %v", body)
+	}
+	msg, _ := body["msg"].(map[string]interface{})
+	text, _ := msg["text"].(map[string]interface{})
+	if text["content"] != "Alice 验证码 123456" {
+		t.Errorf("content = %v", text["content"])
+	}
+	// 目录中有钉钉账号时不按手机号查找
+	if len(fake.mobileCalls) != 0 {
+		t.Errorf("getbymobile called: %v", fake.mobileCalls)
+	}
+}
+
+func TestDingTalkAccessTokenCache(t *testing.T) {
+	s, fake := newTestDingTalk(t, true)
+	user := &LDAPUser{DingTalk: "alice01"}
+
+	for i := 0; i < 3; i++ {
+		if err := s.SendCode(user, "123456"); err != nil {
+			t.Fatalf("SendCode: %v", err)
+		}
+	}
+	if fake.tokenCalls != 1 {
+		t.Errorf("gettoken called %d times, want 1", fake.tokenCalls)
+	}
+	for _, token := range fake.sendTokens {
+		if token != "token-1" {
+			t.Errorf("access_token = %q, want token-1", token)
+		}
+	}
+
+	// 缓存过期后重新获取
+	s.tokens.mu.Lock()
+	s.tokens.expires = time.Now().Add(-time.Second)
+	s.tokens.mu.Unlock()
+	if err := s.SendCode(user, "123456"); err != nil {
+		t.Fatalf("SendCode: %v", err)
+	}
+	if fake.tokenCalls != 2 || fake.sendTokens[len(fake.sendTokens)-1] != "token-2" {
+		t.Errorf("token not refreshed after expiry: calls=%d tokens=%v", fake.tokenCalls, fake.sendTokens)
+	}
+}
+
+func TestDingTalkAccessTokenRefresh(t *testing.T) {
+	for _, code := range []int{imErrInvalidToken, imErrExpiredToken} {
+		t.Run(fmt.Sprint(code), func(t *testing.T) {
+			s, fake := newTestDingTalk(t, true)
+			fake.sendErrors = []int{code}
+
+			// 平台返回令牌失效时丢弃缓存并重试一次
+			if err := s.SendCode(&LDAPUser{DingTalk: "alice01"}, "123456"); err != nil {
+				t.Fatalf("SendCode: %v", err)
+			}
+			if fake.tokenCalls != 2 {
+				t.Errorf("gettoken called %d times, want 2", fake.tokenCalls)
+			}
+			if strings.Join(fake.sendTokens, ",") != "token-1,token-2" {
+				t.Errorf("send tokens = %v", fake.sendTokens)
+			}
+		})
+	}
+
+	// 刷新后仍然失效时只重试一次
+	s, fake := newTestDingTalk(t, true)
+	fake.sendErrors = []int{imErrInvalidToken, imErrInvalidToken, 0}
+	if err := s.SendCode(&LDAPUser{DingTalk: "alice01"}, "123456"); err == nil {
+		t.Error("SendCode succeeded with a persistently invalid token")
+	}
+	if len(fake.sendTokens) != 2 {
+		t.Errorf("send called %d times, want 2", len(fake.sendTokens))
+	}
+}
+
+func TestDingTalkResolveByMobile(t *testing.T) {
+	s, fake := newTestDingTalk(t, true)
+	if err := s.SendCode(&LDAPUser{Mobile: "13800000000"}, "123456"); err != nil {
+		t.Fatalf("SendCode: %v", err)
+	}
+	if len(fake.mobileCalls) != 1 || fake.mobileCalls[0] != "13800000000" {
+		t.Errorf("getbymobile calls = %v", fake.mobileCalls)
+	}
+	if got := fake.sendBodies[0]["userid_list"]; got != "uid-13800000000" {
+		t.Errorf("userid_list = %v", got)
+	}
+
+	// 手机号找不到钉钉用户时不发送
+	fake.mobileErr = 60121
+	err := s.SendCode(&LDAPUser{Mobile: "13900000000"}, "123456")
+	if err == nil || !strings.Contains(err.Error(), "60121") {
+		t.Errorf("SendCode with unknown mobile = %v", err)
+	}
+	if len(fake.sendBodies) != 1 {
+		t.Errorf("message sent for unresolved user")
+	}
+
+	// 关闭按手机号查找后，没有钉钉账号的用户不可用
+	s, fake = newTestDingTalk(t, false)
+	if err := s.SendCode(&LDAPUser{Mobile: "13800000000"}, "123456"); err == nil {
+		t.Error("SendCode without dingtalk account succeeded")
+	}
+	if len(fake.mobileCalls) != 0 || fake.tokenCalls != 0 {
+		t.Errorf("platform called: mobile=%v token=%d", fake.mobileCalls, fake.tokenCalls)
+	}
+}
+
+func TestDingTalkErrCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		tokenErr int
+		sendErr  int
+		want     string
+	}{
+		{"gettoken", 40089, 0, "dingtalk: error 40089: invalid appkey"},
+		{"send", 0, 88, "dingtalk: error 88: msg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fake := newTestDingTalk(t, true)
+			fake.tokenErr = tt.tokenErr
+			if tt.sendErr != 0 {
+				fake.sendErrors = []int{tt.sendErr}
+			}
+			err := s.SendCode(&LDAPUser{DingTalk: "alice01"}, "123456")
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("SendCode = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
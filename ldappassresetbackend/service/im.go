@@ -0,0 +1,142 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// 企业微信、钉钉等即时通讯平台的公共部分
+
+var imHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// 企业微信和钉钉 access_token 无效或过期的错误码
+const (
+	imErrInvalidToken = 40014
+	imErrExpiredToken = 42001
+)
+
+// 提前刷新的时间，避免令牌在请求途中过期
+const accessTokenRefreshMargin = 5 * time.Minute
+
+// accessTokenCache 缓存企业微信、钉钉等开放平台的 access_token，过期前自动刷新
+type accessTokenCache struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	fetch   func() (token string, ttl time.Duration, err error)
+}
+
+func newAccessTokenCache(fetch func() (string, time.Duration, error)) *accessTokenCache {
+	return &accessTokenCache{fetch: fetch}
+}
+
+// get 返回缓存的令牌，即将过期时重新获取
+func (c *accessTokenCache) get() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+	token, ttl, err := c.fetch()
+	if err != nil {
+		return "", err
+	}
+	if ttl > 2*accessTokenRefreshMargin {
+		ttl -= accessTokenRefreshMargin
+	}
+	c.token = token
+	c.expires = time.Now().Add(ttl)
+	return token, nil
+}
+
+// invalidate 平台返回令牌失效时丢弃缓存，下次调用重新获取
+func (c *accessTokenCache) invalidate(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token == token {
+		c.token = ""
+	}
+}
+
+// 调用平台 JSON 接口，body 为 nil 时发送 GET 请求
+func doIMRequest(url string, body, out interface{}) error {
+	method := http.MethodGet
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		method = http.MethodPost
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := imHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, data)
+	}
+	return json.Unmarshal(data, out)
+}
+
+// 企业微信和钉钉的接口响应都包含 errcode 和 errmsg
+type imResponse struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+type imResult interface {
+	errCode() int
+	err() error
+}
+
+func (r imResponse) errCode() int {
+	return r.ErrCode
+}
+
+func (r imResponse) err() error {
+	if r.ErrCode != 0 {
+		return fmt.Errorf("error %d: %s", r.ErrCode, r.ErrMsg)
+	}
+	return nil
+}
+
+// 带 access_token 调用接口，令牌失效时刷新后重试一次
+func callWithToken(tokens *accessTokenCache, endpoint string, body interface{}, out imResult) error {
+	for attempt := 0; ; attempt++ {
+		token, err := tokens.get()
+		if err != nil {
+			return err
+		}
+		if err := doIMRequest(endpoint+"?access_token="+url.QueryEscape(token), body, out); err != nil {
+			return err
+		}
+		if c := out.errCode(); attempt == 0 && (c == imErrInvalidToken || c == imErrExpiredToken) {
+			tokens.invalidate(token)
+			continue
+		}
+		return out.err()
+	}
+}
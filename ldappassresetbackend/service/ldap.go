@@ -3,14 +3,15 @@ package service
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
-	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-ldap/ldap/v3"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/net/ghttp"
-	"golang.org/x/text/encoding/unicode"
 )
 
 type LDAPService struct {
@@ -20,119 +21,208 @@ type LDAPService struct {
 	baseDn        string
 	adminUser     string
 	adminPassword string
-	conn          *ldap.Conn
+	pool          *ldapPool
+
+	// 属性映射，兼容 AD、OpenLDAP、FreeIPA 等不同目录
+	loginAttrs   []string // 可用于登录查找的属性
+	mobileAttr   string   // 手机号属性
+	mailAttr     string   // 邮箱属性
+	nameAttr     string   // 显示名称属性
+	weComAttr    string   // 企业微信账号属性，可为空
+	dingTalkAttr string   // 钉钉账号属性，可为空
+	groupAttr    string   // 所属组属性
+	nestedGroups bool     // 是否展开嵌套组，仅支持 AD
+	userFilter   string   // 用户搜索过滤器模板，{username} 会被替换为用户输入
+
+	flavor         string // 目录类型，ad 或 openldap
+	passwordMethod string // 非 AD 目录的密码修改方式，exop 或 replace
 }
 
-var (
-	ldapServiceInstance *LDAPService
+// 默认属性映射与 Active Directory 保持一致
+const (
+	defaultMobileAttr = "mobile"
+	defaultMailAttr   = "mail"
+	defaultNameAttr   = "name"
+	defaultGroupAttr  = "memberOf"
 )
 
-func NewLDAPService() (*LDAPService, error) {
+var defaultLoginAttrs = []string{"sAMAccountName"}
 
-	var initErr error = nil // 用来记录初始化过程中的错误
+var (
+	ldapServiceInstance *LDAPService
+	ldapServiceOnce     sync.Once
+)
 
-	cfg := g.Cfg().MustGet(context.TODO(), "ldap").Map()
-	host, _ := cfg["host"].(string)
-	port, _ := cfg["port"].(string)
-	disableTLS, _ := cfg["disableTLS"].(bool)
-	baseDn, _ := cfg["baseDn"].(string)
-	adminUser, _ := cfg["adminUser"].(string)
-	adminPassword, _ := cfg["adminPassword"].(string)
+// GetLDAPService 返回全局共享的 LDAP 服务，并确认连接池可以拿到可用连接
+func GetLDAPService() (*LDAPService, error) {
+	ldapServiceOnce.Do(func() {
+		ctx := context.TODO()
+		cfg := g.Cfg().MustGet(ctx, "ldap").Map()
+		host, _ := cfg["host"].(string)
+		port, _ := cfg["port"].(string)
+		disableTLS, _ := cfg["disableTLS"].(bool)
+		baseDn, _ := cfg["baseDn"].(string)
+		adminUser, _ := cfg["adminUser"].(string)
+		adminPassword, _ := cfg["adminPassword"].(string)
+
+		// 连接池配置，时间单位为秒
+		poolSize := g.Cfg().MustGet(ctx, "ldap.pool.size", 10).Int()
+		acquireTimeout := g.Cfg().MustGet(ctx, "ldap.pool.acquireTimeout", 10).Int()
+		idleTimeout := g.Cfg().MustGet(ctx, "ldap.pool.idleTimeout", 300).Int()
+		healthCheckInterval := g.Cfg().MustGet(ctx, "ldap.pool.healthCheckInterval", 30).Int()
+
+		// 目录类型与密码修改方式
+		flavor := g.Cfg().MustGet(ctx, "ldap.flavor", flavorAD).String()
+		passwordMethod := g.Cfg().MustGet(ctx, "ldap.passwordMethod", passwordMethodExop).String()
+
+		// 属性映射配置
+		loginAttrs := g.Cfg().MustGet(ctx, "ldap.attributes.login").Strings()
+		if len(loginAttrs) == 0 {
+			loginAttrs = defaultLoginAttrs
+		}
+		mobileAttr := g.Cfg().MustGet(ctx, "ldap.attributes.mobile", defaultMobileAttr).String()
+		mailAttr := g.Cfg().MustGet(ctx, "ldap.attributes.mail", defaultMailAttr).String()
+		nameAttr := g.Cfg().MustGet(ctx, "ldap.attributes.displayName", defaultNameAttr).String()
+		weComAttr := g.Cfg().MustGet(ctx, "ldap.attributes.wecom").String()
+		dingTalkAttr := g.Cfg().MustGet(ctx, "ldap.attributes.dingtalk").String()
+		groupAttr := g.Cfg().MustGet(ctx, "ldap.attributes.memberOf", defaultGroupAttr).String()
+		nestedGroups := g.Cfg().MustGet(ctx, "ldap.nestedGroups", false).Bool()
+		userFilter := g.Cfg().MustGet(ctx, "ldap.userFilter").String()
+		if userFilter == "" {
+			userFilter = buildUserFilter(loginAttrs, mobileAttr, mailAttr)
+		}
 
-	ldapServiceInstance = &LDAPService{
-		host:          host,
-		port:          port,
-		disableTLS:    disableTLS,
-		baseDn:        baseDn,
-		adminUser:     adminUser,
-		adminPassword: adminPassword,
-	}
+		ldapServiceInstance = &LDAPService{
+			host:           host,
+			port:           port,
+			disableTLS:     disableTLS,
+			baseDn:         baseDn,
+			adminUser:      adminUser,
+			adminPassword:  adminPassword,
+			loginAttrs:     loginAttrs,
+			mobileAttr:     mobileAttr,
+			mailAttr:       mailAttr,
+			nameAttr:       nameAttr,
+			weComAttr:      weComAttr,
+			dingTalkAttr:   dingTalkAttr,
+			groupAttr:      groupAttr,
+			nestedGroups:   nestedGroups,
+			userFilter:     userFilter,
+			flavor:         flavor,
+			passwordMethod: passwordMethod,
+		}
+		ldapServiceInstance.pool = newLDAPPool(
+			poolSize,
+			time.Duration(acquireTimeout)*time.Second,
+			time.Duration(idleTimeout)*time.Second,
+			time.Duration(healthCheckInterval)*time.Second,
+			ldapServiceInstance.connect,
+		)
+	})
 
-	if err := ldapServiceInstance.connect(); err != nil {
-		initErr = fmt.Errorf("failed to connect to LDAP server: %w", err)
+	// 预先取一次连接，LDAP 不可用时尽早返回错误
+	if err := ldapServiceInstance.withConn(func(*ldap.Conn) error { return nil }); err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %w", err)
 	}
+	return ldapServiceInstance, nil
+}
 
-	// 如果初始化过程中出现错误，返回错误信息
-	if initErr != nil {
-		return nil, initErr
+// CloseLDAPService 服务停止时关闭连接池
+func CloseLDAPService() {
+	if ldapServiceInstance != nil {
+		ldapServiceInstance.pool.close()
 	}
-
-	// 返回初始化后的实例
-	return ldapServiceInstance, nil
 }
 
-func (s *LDAPService) connect() error {
+// 建立连接，不进行绑定
+func (s *LDAPService) dial() (*ldap.Conn, error) {
 	ldapURL := fmt.Sprintf("%s:%s", s.host, s.port)
-	conn, err := ldap.DialURL(ldapURL, ldap.DialWithTLSConfig(&tls.Config{
+	return ldap.DialURL(ldapURL, ldap.DialWithTLSConfig(&tls.Config{
 		InsecureSkipVerify: s.disableTLS,
 	}))
+}
+
+// 建立连接并以管理员身份绑定
+func (s *LDAPService) connect() (*ldap.Conn, error) {
+	conn, err := s.dial()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if err := conn.Bind(s.adminUser, s.adminPassword); err != nil {
-		return err
+		conn.Close()
+		return nil, err
 	}
-	s.conn = conn
-	return nil
+	return conn, nil
 }
 
-// Validate password with multiple checks
-func validatePassword(password string) error {
-	// Check minimum length
-	if len(password) < 8 {
-		return fmt.Errorf("verification failed")
-	}
-
-	// Check if contains at least one letter
-	if !regexp.MustCompile(`[A-Za-z]`).MatchString(password) {
-		return fmt.Errorf("verification failed")
-	}
-
-	// Check if contains at least one digit
-	if !regexp.MustCompile(`\d`).MatchString(password) {
-		return fmt.Errorf("verification failed")
+// 根据登录属性和联系方式属性生成默认过滤器，用户可以用任意一个属性查找
+func buildUserFilter(loginAttrs []string, mobileAttr, mailAttr string) string {
+	var b strings.Builder
+	b.WriteString("(|")
+	for _, attr := range append(append([]string{}, loginAttrs...), mobileAttr, mailAttr) {
+		if attr == "" {
+			continue
+		}
+		b.WriteString("(" + attr + "={username})")
 	}
+	b.WriteString(")")
+	return b.String()
+}
 
-	// Check for allowed characters
-	if strings.ContainsAny(password, " ") || !regexp.MustCompile(`^[A-Za-z\d!@#$%^&*(),.?":{}|<>]+$`).MatchString(password) {
-		return fmt.Errorf("verification failed")
+// withConn 从连接池取出连接执行操作，遇到网络错误时丢弃该连接并重新绑定重试一次
+func (s *LDAPService) withConn(fn func(conn *ldap.Conn) error) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var conn *ldap.Conn
+		conn, err = s.pool.get()
+		if err != nil {
+			return err
+		}
+		err = fn(conn)
+		s.pool.put(conn, err)
+		if !isNetworkError(err) {
+			return err
+		}
 	}
-	return nil
+	return err
 }
 
 func ResetPassword(r *ghttp.Request) {
 	username := r.Get("username").String()
-	codeType := r.Get("type").String()
-	ldapService, err := NewLDAPService()
+	ldapService, err := GetLDAPService()
 	if err != nil {
 		r.Response.WriteJsonExit(g.Map{"code": 10007, "message": err.Error()})
 		return
 	}
-	// 获取用户的手机号码和邮箱
-	mobile, mail, _, err := ldapService.GetUser(username)
+	// 获取用户信息
+	user, err := lookupUser(ldapService, username)
 	if err != nil {
 		r.Response.WriteJsonExit(g.Map{"code": 10008, "message": err.Error()})
 		return
 	}
-	// 判断认证方式
-	var identifier string
-
-	if codeType == "mail" {
-		identifier = mail
-	}
-
-	if codeType == "mobile" {
-		identifier = mobile
+	// 受保护的账户不允许自助重置，即使已持有令牌
+	user = checkProtected(r, auditPasswordReset, user)
+	// 隐私模式下不存在或受保护的用户不可能持有有效令牌，按令牌无效处理
+	if user.fake {
+		r.Response.WriteJsonExit(g.Map{"code": 10018, "message": ErrInvalidToken.Error()})
+		return
 	}
-
-	if identifier == "" {
-		r.Response.WriteJsonExit(g.Map{"code": 1005, "message": "Invalid code"})
+	// 校验重置令牌
+	claims, err := checkResetToken(r, user)
+	if err != nil {
+		audit(r, auditPasswordReset, auditFailure, user, "", err.Error())
+		r.Response.WriteJsonExit(g.Map{"code": 10018, "message": err.Error()})
 		return
 	}
-	// 校验验证码
-	code := r.Get("verifyCode").String()
-	if !VerifyCode(identifier, code) {
-		r.Response.WriteJsonExit(g.Map{"code": 1006, "message": "Invalid code"})
+
+	// 特权账户需要在同一会话中通过策略要求的全部验证方式
+	if err := checkFactors(user, claims); err != nil {
+		audit(r, auditPasswordReset, auditFailure, user, claims.Channel, err.Error())
+		r.Response.WriteJsonExit(g.Map{
+			"code":    10027,
+			"message": err.Error(),
+			"pending": pendingFactors(user, claims.factors()),
+		})
 		return
 	}
 
@@ -141,28 +231,50 @@ func ResetPassword(r *ghttp.Request) {
 	// 解密密码
 	decryptedPassword, err := DecryptPassword(newPassword)
 	if err != nil {
+		audit(r, auditPasswordReset, auditFailure, user, claims.Channel, "failed to decrypt password")
 		r.Response.WriteJsonExit(g.Map{
 			"code":    10002,
 			"message": "Failed to decrypt password",
 		})
 	}
 	// 二次校验密码
-	if err := validatePassword(decryptedPassword); err != nil {
+	if err := validatePassword(decryptedPassword, user); err != nil {
+		audit(r, auditPasswordReset, auditFailure, user, claims.Channel, err.Error())
+		if errors.Is(err, ErrBreachedPassword) {
+			r.Response.WriteJsonExit(g.Map{
+				"code":    10017,
+				"message": err.Error(),
+			})
+		}
 		r.Response.WriteJson(g.Map{
 			"code":    10003,
 			"message": err.Error(),
 		})
 		return
 	}
-	// 发起重置密码请求
-	if err := ldapService.Reset(username, decryptedPassword); err != nil {
+	// 密码校验通过后再消费令牌，避免因密码不合规而需要重新验证
+	if err := consumeResetToken(claims); err != nil {
+		audit(r, auditPasswordReset, auditFailure, user, claims.Channel, err.Error())
+		r.Response.WriteJsonExit(g.Map{"code": 10018, "message": err.Error()})
+		return
+	}
+	// 发起重置密码请求，目标为令牌中绑定的账户
+	if err := ldapService.Reset(claims.DN, decryptedPassword); err != nil {
+		audit(r, auditPasswordReset, auditFailure, user, claims.Channel, err.Error())
+		if errors.Is(err, ErrAccountDisabled) {
+			r.Response.WriteJsonExit(g.Map{
+				"code":    10014,
+				"message": err.Error(),
+			})
+		}
 		r.Response.WriteJsonExit(g.Map{
 			"code":    10004,
 			"message": err.Error(),
 		})
 		return
 	}
-	DeleteCode(identifier) // 删除验证码
+	audit(r, auditPasswordReset, auditSuccess, user, claims.Channel, "")
+	notifyPasswordChanged(r, user, "重置密码")
 	r.Response.WriteJsonExit(g.Map{
 		"code":    200,
 		"message": "Success",
@@ -170,9 +282,11 @@ func ResetPassword(r *ghttp.Request) {
 }
 
 func GetUserInfo(r *ghttp.Request) {
+	defer padResponseTime(time.Now())
+
 	username := r.Get("username").String()
 	// 查找用户信息
-	ldapService, err := NewLDAPService()
+	ldapService, err := GetLDAPService()
 	if err != nil {
 		r.Response.WriteJsonExit(g.Map{
 			"code":    10007,
@@ -182,8 +296,9 @@ func GetUserInfo(r *ghttp.Request) {
 	}
 
 	// 获取用户的手机号码和邮箱
-	mobile, mail, _, err := ldapService.GetUser(username)
+	user, err := lookupUser(ldapService, username)
 	if err != nil {
+		audit(r, auditLookup, auditFailure, nil, "", err.Error())
 		if err.Error() == "user not found" {
 			r.Response.WriteJsonExit(g.Map{
 				"code":    10012,
@@ -197,18 +312,32 @@ func GetUserInfo(r *ghttp.Request) {
 		return
 	}
 
-	// 对手机号进行打码
-	maskedMobile := maskMobile(mobile)
+	user = checkProtected(r, auditLookup, user)
+	audit(r, auditLookup, auditSuccess, user, "", "")
+	if user.Locked {
+		audit(r, auditAccountLocked, auditDetected, user, "", "")
+	}
 
-	// 对邮箱进行打码
-	maskedMail := maskMail(mail)
+	r.Response.WriteJsonExit(userInfo(user, username))
+}
 
-	// 返回打码后的信息
-	r.Response.WriteJsonExit(g.Map{
-		"code":   200,
-		"mobile": maskedMobile,
-		"mail":   maskedMail,
-	})
+// 返回打码后的联系方式及可用的验证方式
+// 隐私模式下补齐缺失的联系方式并隐藏锁定状态，避免由返回内容判断账户是否存在
+func userInfo(user *LDAPUser, username string) g.Map {
+	shown := withPrivacyContacts(user, username)
+	info := g.Map{
+		"code":      200,
+		"mobile":    maskMobile(shown.Mobile),
+		"mail":      maskMail(shown.Mail),
+		"wecom":     weComAvailable(shown),
+		"dingtalk":  dingTalkAvailable(shown),
+		"totp":      totpEnrolled(user),
+		"questions": questionsEnrolled(user),
+	}
+	if !isPrivacyMode() {
+		info["locked"] = user.Locked
+	}
+	return info
 }
 
 // 打码手机号
@@ -249,71 +378,301 @@ func maskMail(mail string) string {
 	return fmt.Sprintf("%s@%s.%s", maskedLocal, maskedDomain, domainSuffix)
 }
 
-func (s *LDAPService) Reset(username, newPassword string) error {
-	if s.conn == nil {
-		if err := s.connect(); err != nil {
-			return fmt.Errorf("failed to reconnect to LDAP server: %v", err)
+// Reset 重置指定 DN 的密码，DN 取自重置令牌，不再按用户名重新查找
+func (s *LDAPService) Reset(userDN, newPassword string) error {
+	// AD 下读取当前账户标志，禁用账户直接拒绝，已锁定的账户重置时一并解锁
+	var unlock bool
+	if s.flavor == flavorAD {
+		entry, err := s.readEntry(userDN, []string{"userAccountControl", "lockoutTime", "msDS-User-Account-Control-Computed"})
+		if err != nil {
+			return err
 		}
+		if err := checkUserAccountControl(entry.GetAttributeValue("userAccountControl")); err != nil {
+			return err
+		}
+		unlock = isLockedOut(entry)
 	}
 
-	searchRequest := ldap.NewSearchRequest(
-		s.baseDn,
-		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		fmt.Sprintf("(|(sAMAccountName=%s)(mobile=%s)(mail=%s))", username, username, username),
-		[]string{"dn"},
-		nil,
-	)
-	sr, err := s.conn.Search(searchRequest)
-	if err != nil || len(sr.Entries) == 0 {
-		return fmt.Errorf("user not found")
+	err := s.withConn(func(conn *ldap.Conn) error {
+		if s.flavor == flavorAD {
+			return resetPasswordAD(conn, userDN, newPassword, unlock)
+		}
+		return resetPasswordStandard(conn, userDN, newPassword, s.passwordMethod)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to modify password: %v", err)
+	}
+	return nil
+}
+
+// ChangePassword 以用户自身身份绑定后修改密码，不使用管理员连接
+// AD 中密码已过期或须在下次登录时修改的账户无法绑定，此时改用服务账号连接提交修改，旧密码仍由 DC 校验
+func (s *LDAPService) ChangePassword(username, oldPassword, newPassword string) error {
+	entry, err := s.searchUser(username, []string{"dn"})
+	if err != nil {
+		return err
+	}
+
+	conn, err := s.bindAsUser(entry.DN, oldPassword)
+	switch {
+	case err == nil:
+		defer conn.Close()
+		if s.flavor == flavorAD {
+			err = changePasswordAD(conn, entry.DN, oldPassword, newPassword)
+		} else {
+			err = changePasswordStandard(conn, entry.DN, oldPassword, newPassword)
+		}
+	case errors.Is(err, ErrPasswordExpired) && s.flavor == flavorAD:
+		err = s.withConn(func(conn *ldap.Conn) error {
+			return changePasswordAD(conn, entry.DN, oldPassword, newPassword)
+		})
+	default:
+		return err
+	}
+	if err != nil {
+		if isADWrongPassword(err) {
+			return ErrInvalidCredentials
+		}
+		return fmt.Errorf("failed to change password: %v", err)
 	}
+	return nil
+}
 
-	userDN := sr.Entries[0].DN
+// VerifyPassword 以用户身份绑定，校验当前密码是否正确
+func (s *LDAPService) VerifyPassword(dn, password string) error {
+	conn, err := s.bindAsUser(dn, password)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
 
-	utf16 := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
-	pwdEncoded, err := utf16.NewEncoder().String("\"" + newPassword + "\"")
+// 以用户身份建立连接，调用方负责关闭
+func (s *LDAPService) bindAsUser(dn, password string) (*ldap.Conn, error) {
+	// 单独建立连接，避免用户身份的连接回到管理员连接池
+	conn, err := s.dial()
 	if err != nil {
-		return fmt.Errorf("failed to modify password: %v", err)
+		return nil, fmt.Errorf("failed to connect to LDAP server: %v", err)
+	}
+	// 空密码会被视为匿名绑定而成功，必须拒绝
+	if password == "" {
+		conn.Close()
+		return nil, ErrInvalidCredentials
+	}
+	if err := conn.Bind(dn, password); err != nil {
+		conn.Close()
+		if err := bindError(err); errors.Is(err, ErrInvalidCredentials) || errors.Is(err, ErrPasswordExpired) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to bind as user: %v", err)
 	}
+	return conn, nil
+}
 
-	passwordModify := ldap.NewModifyRequest(userDN, nil)
-	passwordModify.Replace("unicodePwd", []string{pwdEncoded})
-	passwordModify.Replace("userAccountControl", []string{"512"})
-	err = s.conn.Modify(passwordModify)
+// Unlock 解除指定 DN 的账户锁定，不修改密码
+func (s *LDAPService) Unlock(userDN string) error {
+	if s.flavor != flavorAD {
+		return fmt.Errorf("unlock is only supported on Active Directory")
+	}
+	if userDN == "" {
+		return fmt.Errorf("user not found")
+	}
+	err := s.withConn(func(conn *ldap.Conn) error {
+		return unlockAccountAD(conn, userDN)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to modify password: %v", err)
+		return fmt.Errorf("failed to unlock account: %v", err)
 	}
 	return nil
 }
 
-func (s *LDAPService) GetUser(username string) (string, string, string, error) {
-	if s.conn == nil {
-		if err := s.connect(); err != nil {
-			return "", "", "", fmt.Errorf("failed to reconnect to LDAP server:  %v", err)
+// LDAPUser 查找到的用户信息
+type LDAPUser struct {
+	DN       string
+	Username string // 登录名，取第一个登录属性
+	Mobile   string
+	Mail     string
+	Name     string
+	WeCom    string   // 企业微信账号
+	DingTalk string   // 钉钉账号
+	Groups   []string // 所属组的 DN
+	Locked   bool     // 账户是否处于锁定状态
+	PSO      string   // 生效的细粒度密码策略 DN
+
+	attributes map[string][]string // 拒绝规则等需要的其他属性，key 为小写属性名
+	fake       bool                // 隐私模式下为不存在的用户生成的假数据
+}
+
+// 读取其他属性的第一个值
+func (u *LDAPUser) attribute(name string) string {
+	if values := u.attributes[strings.ToLower(name)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// memberOf 用户是否属于指定的组，DN 比较忽略大小写及空白差异
+func (u *LDAPUser) memberOf(group string) bool {
+	target, err := ldap.ParseDN(group)
+	if err != nil {
+		return false
+	}
+	for _, v := range u.Groups {
+		if dn, err := ldap.ParseDN(v); err == nil && dn.EqualFold(target) {
+			return true
 		}
 	}
+	return false
+}
+
+func (s *LDAPService) GetUser(username string) (*LDAPUser, error) {
+	attributes := []string{
+		s.loginAttrs[0], s.mobileAttr, s.mailAttr, s.nameAttr, s.groupAttr,
+		"lockoutTime", "msDS-User-Account-Control-Computed", "msDS-ResultantPSO",
+	}
+	for _, attr := range []string{s.weComAttr, s.dingTalkAttr} {
+		if attr != "" {
+			attributes = append(attributes, attr)
+		}
+	}
+	extra := denyRuleAttributes()
+	attributes = append(attributes, extra...)
+	entry, err := s.searchUser(username, attributes)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := s.userGroups(entry)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string][]string, len(extra))
+	for _, attr := range extra {
+		values[strings.ToLower(attr)] = entry.GetEqualFoldAttributeValues(attr)
+	}
 
-	// 创建搜索请求，查找用户名匹配的用户
+	return &LDAPUser{
+		DN:       entry.DN,
+		Username: entry.GetAttributeValue(s.loginAttrs[0]),
+		Mobile:   entry.GetAttributeValue(s.mobileAttr),
+		Mail:     entry.GetAttributeValue(s.mailAttr),
+		Name:     entry.GetAttributeValue(s.nameAttr),
+		WeCom:    entry.GetAttributeValue(s.weComAttr),
+		DingTalk: entry.GetAttributeValue(s.dingTalkAttr),
+		Groups:   groups,
+		Locked:   isLockedOut(entry),
+		PSO:      entry.GetAttributeValue("msDS-ResultantPSO"),
+
+		attributes: values,
+	}, nil
+}
+
+// 解析用户所属的组，开启 nestedGroups 时通过 AD 的 LDAP_MATCHING_RULE_IN_CHAIN 展开嵌套组
+func (s *LDAPService) userGroups(entry *ldap.Entry) ([]string, error) {
+	if !s.nestedGroups || s.flavor != flavorAD {
+		return entry.GetEqualFoldAttributeValues(s.groupAttr), nil
+	}
 	searchRequest := ldap.NewSearchRequest(
 		s.baseDn,
 		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		fmt.Sprintf("(|(sAMAccountName=%s)(mobile=%s)(mail=%s))", username, username, username),
-		[]string{"sAMAccountName", "mobile", "mail", "name"}, // 请求返回这些属性
+		"(member:1.2.840.113556.1.4.1941:="+ldap.EscapeFilter(entry.DN)+")",
+		[]string{"dn"},
+		nil,
+	)
+	var groups []string
+	err := s.withConn(func(conn *ldap.Conn) error {
+		sr, err := conn.SearchWithPaging(searchRequest, 500)
+		if err != nil {
+			return err
+		}
+		for _, e := range sr.Entries {
+			groups = append(groups, e.DN)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve groups: %v", err)
+	}
+	return groups, nil
+}
+
+// 按配置的过滤器查找用户，要求有且仅有一个匹配的条目
+func (s *LDAPService) searchUser(username string, attributes []string) (*ldap.Entry, error) {
+	// 拒绝通配符及控制字符，避免模糊匹配到其他用户
+	if err := validateUsername(username); err != nil {
+		return nil, err
+	}
+
+	// 创建搜索请求，最多返回两条，足以判断是否唯一
+	searchRequest := ldap.NewSearchRequest(
+		s.baseDn,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		s.userSearchFilter(username),
+		attributes, // 请求返回这些属性
 		nil,
 	)
 
 	// 执行搜索
-	sr, err := s.conn.Search(searchRequest)
-	if err != nil || len(sr.Entries) == 0 {
-		return "", "", "", fmt.Errorf("user not found")
+	var sr *ldap.SearchResult
+	err := s.withConn(func(conn *ldap.Conn) error {
+		var err error
+		sr, err = conn.Search(searchRequest)
+		return err
+	})
+	return singleUserEntry(sr, err)
+}
+
+// 按 DN 读取条目
+func (s *LDAPService) readEntry(dn string, attributes []string) (*ldap.Entry, error) {
+	if dn == "" {
+		return nil, fmt.Errorf("user not found")
 	}
+	searchRequest := ldap.NewSearchRequest(
+		dn,
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false,
+		"(objectClass=*)",
+		attributes,
+		nil,
+	)
+	var sr *ldap.SearchResult
+	err := s.withConn(func(conn *ldap.Conn) error {
+		var err error
+		sr, err = conn.Search(searchRequest)
+		return err
+	})
+	return singleUserEntry(sr, err)
+}
 
-	// 获取第一个匹配的条目的属性值
-	entry := sr.Entries[0]
+// 用户输入按 RFC 4515 转义后再拼接到过滤器中
+func (s *LDAPService) userSearchFilter(username string) string {
+	return strings.ReplaceAll(s.userFilter, "{username}", ldap.EscapeFilter(username))
+}
 
-	mobile := entry.GetAttributeValue("mobile")
-	mail := entry.GetAttributeValue("mail")
-	name := entry.GetAttributeValue("name")
+// 从搜索结果中取出唯一的用户条目，超出条数限制说明匹配到了多个用户
+func singleUserEntry(sr *ldap.SearchResult, err error) (*ldap.Entry, error) {
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("user not found")
+	}
+	if sr == nil || len(sr.Entries) == 0 {
+		return nil, fmt.Errorf("user not found")
+	}
+	// 匹配到多个用户时无法确定目标，直接拒绝
+	if len(sr.Entries) > 1 || err != nil {
+		return nil, fmt.Errorf("multiple users matched")
+	}
 
-	return mobile, mail, name, nil
+	return sr.Entries[0], nil
+}
+
+// 校验用户输入，只允许精确查找
+func validateUsername(username string) error {
+	if strings.TrimSpace(username) == "" {
+		return fmt.Errorf("user not found")
+	}
+	for _, c := range username {
+		if c == '*' || c < 0x20 || c == 0x7f {
+			return fmt.Errorf("invalid username")
+		}
+	}
+	return nil
 }
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// 目录类型
+const (
+	flavorAD       = "ad"       // Active Directory
+	flavorOpenLDAP = "openldap" // OpenLDAP、389-DS、FreeIPA 等标准 LDAP 目录
+)
+
+// 非 AD 目录的密码修改方式
+const (
+	passwordMethodExop    = "exop"    // RFC 3062 Password Modify 扩展操作
+	passwordMethodReplace = "replace" // 直接替换 userPassword，由服务端哈希
+)
+
+// userAccountControl 标志位，锁定标志只出现在计算属性 msDS-User-Account-Control-Computed 中
+const (
+	uacAccountDisable = 0x0002 // 账户已禁用
+	uacLockout        = 0x0010 // 账户已锁定
+)
+
+// ErrAccountDisabled 账户已禁用，不允许自助重置
+var ErrAccountDisabled = errors.New("account disabled")
+
+// ErrInvalidCredentials 当前密码错误
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrPasswordExpired 当前密码正确，但已过期或须在下次登录时修改，目录拒绝以该密码绑定
+var ErrPasswordExpired = errors.New("password expired or must be changed")
+
+// AD 绑定失败时诊断信息中的子错误码，如 "AcceptSecurityContext error, data 532, v4563"
+var adBindDataPattern = regexp.MustCompile(`\bdata ([0-9a-fA-F]+)\b`)
+
+const (
+	adBindPasswordExpired = "532" // 密码已过期
+	adBindMustChange      = "773" // 须在下次登录时修改密码
+)
+
+// 将绑定错误转换为 ErrInvalidCredentials 或 ErrPasswordExpired，其他错误原样返回
+func bindError(err error) error {
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return err
+	}
+	if m := adBindDataPattern.FindStringSubmatch(err.Error()); m != nil {
+		switch m[1] {
+		case adBindPasswordExpired, adBindMustChange:
+			return ErrPasswordExpired
+		}
+	}
+	return ErrInvalidCredentials
+}
+
+// AD 修改密码时旧密码不匹配返回约束冲突，诊断信息以 00000056 (ERROR_INVALID_PASSWORD) 开头
+func isADWrongPassword(err error) bool {
+	return ldap.IsErrorWithCode(err, ldap.LDAPResultConstraintViolation) && strings.Contains(err.Error(), "00000056")
+}
+
+// 根据当前 userAccountControl 判断能否重置，禁用账户直接拒绝
+// 锁定和密码过期由 AD 计算得出，写入 userAccountControl 无法清除，改为在重置时修改 lockoutTime 和 pwdLastSet
+func checkUserAccountControl(current string) error {
+	if current == "" {
+		return nil
+	}
+	uac, err := strconv.ParseInt(current, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid userAccountControl %q", current)
+	}
+	if uac&uacAccountDisable != 0 {
+		return ErrAccountDisabled
+	}
+	return nil
+}
+
+// 判断账户是否被锁定，优先使用 AD 计算属性 msDS-User-Account-Control-Computed，否则退回到 lockoutTime 判断
+func isLockedOut(entry *ldap.Entry) bool {
+	if computed := entry.GetAttributeValue("msDS-User-Account-Control-Computed"); computed != "" {
+		v, err := strconv.ParseInt(computed, 10, 64)
+		return err == nil && v&uacLockout != 0
+	}
+	lockoutTime := entry.GetAttributeValue("lockoutTime")
+	return lockoutTime != "" && lockoutTime != "0"
+}
+
+// 解锁账户：将 lockoutTime 置为 0，不修改密码
+func unlockAccountAD(conn *ldap.Conn, userDN string) error {
+	unlock := ldap.NewModifyRequest(userDN, nil)
+	unlock.Replace("lockoutTime", []string{"0"})
+	return conn.Modify(unlock)
+}
+
+// AD 重置密码：写入 UTF-16LE 编码且带引号的 unicodePwd
+// pwdLastSet 置为 -1 即当前时间，清除密码过期及下次登录须修改密码的状态；unlock 为 true 时同时将 lockoutTime 置为 0 解除锁定
+func resetPasswordAD(conn *ldap.Conn, userDN, newPassword string, unlock bool) error {
+	passwordModify, err := resetPasswordADRequest(userDN, newPassword, unlock)
+	if err != nil {
+		return err
+	}
+	return conn.Modify(passwordModify)
+}
+
+func resetPasswordADRequest(userDN, newPassword string, unlock bool) (*ldap.ModifyRequest, error) {
+	pwdEncoded, err := encodeADPassword(newPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordModify := ldap.NewModifyRequest(userDN, nil)
+	passwordModify.Replace("unicodePwd", []string{pwdEncoded})
+	passwordModify.Replace("pwdLastSet", []string{"-1"})
+	if unlock {
+		passwordModify.Replace("lockoutTime", []string{"0"})
+	}
+	return passwordModify, nil
+}
+
+// 标准 LDAP 目录重置密码：优先使用 Password Modify 扩展操作，由服务端按 ppolicy 哈希并执行策略
+func resetPasswordStandard(conn *ldap.Conn, userDN, newPassword, method string) error {
+	switch method {
+	case passwordMethodReplace:
+		passwordModify := ldap.NewModifyRequest(userDN, nil)
+		passwordModify.Replace("userPassword", []string{newPassword})
+		return conn.Modify(passwordModify)
+	case passwordMethodExop, "":
+		_, err := conn.PasswordModify(ldap.NewPasswordModifyRequest(userDN, "", newPassword))
+		return err
+	default:
+		return fmt.Errorf("unknown password method %q", method)
+	}
+}
+
+// 将密码编码为 AD 要求的 UTF-16LE 带引号格式
+func encodeADPassword(password string) (string, error) {
+	utf16 := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	return utf16.NewEncoder().String("\"" + password + "\"")
+}
+
+// AD 用户自助修改密码：删除旧 unicodePwd 并添加新值，DC 校验旧密码并执行密码历史和策略检查
+// 该操作只需要"更改密码"权限，默认授予所有人，因此可以用用户连接，也可以用服务账号连接
+func changePasswordAD(conn *ldap.Conn, userDN, oldPassword, newPassword string) error {
+	oldEncoded, err := encodeADPassword(oldPassword)
+	if err != nil {
+		return err
+	}
+	newEncoded, err := encodeADPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	passwordModify := ldap.NewModifyRequest(userDN, nil)
+	passwordModify.Delete("unicodePwd", []string{oldEncoded})
+	passwordModify.Add("unicodePwd", []string{newEncoded})
+	return conn.Modify(passwordModify)
+}
+
+// 标准 LDAP 目录用户自助修改密码：以用户身份发起带旧密码的 Password Modify 扩展操作
+func changePasswordStandard(conn *ldap.Conn, userDN, oldPassword, newPassword string) error {
+	_, err := conn.PasswordModify(ldap.NewPasswordModifyRequest(userDN, oldPassword, newPassword))
+	return err
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestCheckUserAccountControl(t *testing.T) {
+	tests := []struct {
+		name    string
+		uac     string
+		wantErr error
+		invalid bool
+	}{
+		{"empty", "", nil, false},
+		{"normal account", "512", nil, false},
+		{"password never expires", "66048", nil, false},
+		// 存储的值中即使带有锁定或过期标志也不影响重置，这些状态由 lockoutTime 和 pwdLastSet 处理
+		{"stale lockout bit", "528", nil, false},
+		{"stale expired bit", "8389120", nil, false},
+		{"disabled", "514", ErrAccountDisabled, false},
+		{"disabled never expires", "66050", ErrAccountDisabled, false},
+		{"garbage", "abc", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkUserAccountControl(tt.uac)
+			switch {
+			case tt.invalid:
+				if err == nil {
+					t.Errorf("checkUserAccountControl(%q) = nil, want error", tt.uac)
+				}
+			case err != tt.wantErr:
+				t.Errorf("checkUserAccountControl(%q) = %v, want %v", tt.uac, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsLockedOut(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs map[string][]string
+		want  bool
+	}{
+		{"no attributes", nil, false},
+		{"computed locked", map[string][]string{"msDS-User-Account-Control-Computed": {"16"}}, true},
+		{"computed locked and expired", map[string][]string{"msDS-User-Account-Control-Computed": {"8388624"}}, true},
+		{"computed expired only", map[string][]string{"msDS-User-Account-Control-Computed": {"8388608"}}, false},
+		// 锁定时间已过 lockoutDuration 时 lockoutTime 仍非零，以计算属性为准
+		{"computed unlocked with stale lockoutTime", map[string][]string{
+			"msDS-User-Account-Control-Computed": {"0"},
+			"lockoutTime":                        {"133000000000000000"},
+		}, false},
+		{"computed garbage", map[string][]string{"msDS-User-Account-Control-Computed": {"x"}}, false},
+		{"lockoutTime set", map[string][]string{"lockoutTime": {"133000000000000000"}}, true},
+		{"lockoutTime zero", map[string][]string{"lockoutTime": {"0"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := ldap.NewEntry("CN=Alice,DC=example,DC=com", tt.attrs)
+			if got := isLockedOut(entry); got != tt.want {
+				t.Errorf("isLockedOut = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResetPasswordADRequest(t *testing.T) {
+	changes := func(unlock bool) map[string][]string {
+		req, err := resetPasswordADRequest("CN=Alice,DC=example,DC=com", "n3w-Pass", unlock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string][]string)
+		for _, c := range req.Changes {
+			if c.Operation != ldap.ReplaceAttribute {
+				t.Errorf("%s operation = %d, want replace", c.Modification.Type, c.Operation)
+			}
+			got[c.Modification.Type] = c.Modification.Vals
+		}
+		return got
+	}
+
+	got := changes(false)
+	want, _ := encodeADPassword("n3w-Pass")
+	if v := got["unicodePwd"]; len(v) != 1 || v[0] != want {
+		t.Errorf("unicodePwd = %q", v)
+	}
+	// 新密码立即生效，清除过期状态
+	if v := got["pwdLastSet"]; len(v) != 1 || v[0] != "-1" {
+		t.Errorf("pwdLastSet = %v, want [-1]", v)
+	}
+	if _, ok := got["lockoutTime"]; ok {
+		t.Error("lockoutTime modified for unlocked account")
+	}
+	// 不再写回 userAccountControl
+	if _, ok := got["userAccountControl"]; ok {
+		t.Error("userAccountControl modified")
+	}
+
+	if v := changes(true)["lockoutTime"]; len(v) != 1 || v[0] != "0" {
+		t.Errorf("lockoutTime = %v, want [0]", v)
+	}
+}
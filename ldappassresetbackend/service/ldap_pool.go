@@ -0,0 +1,143 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+var (
+	errPoolClosed  = errors.New("ldap pool is closed")
+	errPoolTimeout = errors.New("timed out waiting for an ldap connection")
+)
+
+type pooledConn struct {
+	conn     *ldap.Conn
+	lastUsed time.Time
+}
+
+// ldapPool 长连接池，连接在创建时以管理员身份绑定
+type ldapPool struct {
+	mu                  sync.Mutex
+	idle                []*pooledConn
+	sem                 chan struct{} // 限制最大连接数
+	done                chan struct{} // 关闭时唤醒等待中的请求
+	acquireTimeout      time.Duration // 池满时最长等待时间，0 表示一直等待
+	idleTimeout         time.Duration // 空闲超过该时长的连接直接关闭
+	healthCheckInterval time.Duration // 空闲超过该时长的连接取出前做一次探活
+	dial                func() (*ldap.Conn, error)
+	closed              bool
+}
+
+func newLDAPPool(size int, acquireTimeout, idleTimeout, healthCheckInterval time.Duration, dial func() (*ldap.Conn, error)) *ldapPool {
+	if size <= 0 {
+		size = 1
+	}
+	return &ldapPool{
+		sem:                 make(chan struct{}, size),
+		done:                make(chan struct{}),
+		acquireTimeout:      acquireTimeout,
+		idleTimeout:         idleTimeout,
+		healthCheckInterval: healthCheckInterval,
+		dial:                dial,
+	}
+}
+
+// get 取出一个可用连接，池满时最多等待 acquireTimeout
+func (p *ldapPool) get() (*ldap.Conn, error) {
+	var timeout <-chan time.Time
+	if p.acquireTimeout > 0 {
+		timer := time.NewTimer(p.acquireTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case p.sem <- struct{}{}:
+	case <-p.done:
+		return nil, errPoolClosed
+	case <-timeout:
+		return nil, errPoolTimeout
+	}
+
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			<-p.sem
+			return nil, errPoolClosed
+		}
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		pc := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		idleFor := time.Since(pc.lastUsed)
+		if pc.conn.IsClosing() || (p.idleTimeout > 0 && idleFor > p.idleTimeout) {
+			pc.conn.Close()
+			continue
+		}
+		if p.healthCheckInterval > 0 && idleFor > p.healthCheckInterval && !isAlive(pc.conn) {
+			pc.conn.Close()
+			continue
+		}
+		return pc.conn, nil
+	}
+
+	conn, err := p.dial()
+	if err != nil {
+		<-p.sem
+		return nil, err
+	}
+	return conn, nil
+}
+
+// put 归还连接，出现网络错误的连接直接丢弃
+func (p *ldapPool) put(conn *ldap.Conn, err error) {
+	defer func() { <-p.sem }()
+
+	if isNetworkError(err) || conn.IsClosing() {
+		conn.Close()
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		conn.Close()
+		return
+	}
+	p.idle = append(p.idle, &pooledConn{conn: conn, lastUsed: time.Now()})
+}
+
+// close 关闭所有空闲连接，正在使用的连接在归还时关闭
+func (p *ldapPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.closed {
+		close(p.done)
+	}
+	p.closed = true
+	for _, pc := range p.idle {
+		pc.conn.Close()
+	}
+	p.idle = nil
+}
+
+// 通过读取 RootDSE 判断连接是否可用
+func isAlive(conn *ldap.Conn) bool {
+	_, err := conn.Search(ldap.NewSearchRequest(
+		"", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 5, false,
+		"(objectClass=*)", []string{"1.1"}, nil,
+	))
+	return err == nil
+}
+
+func isNetworkError(err error) bool {
+	return err != nil && ldap.IsErrorWithCode(err, ldap.ErrorNetwork)
+}
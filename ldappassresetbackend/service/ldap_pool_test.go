@@ -0,0 +1,151 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// 返回基于内存管道的连接池，dials 记录建立连接的次数
+func newTestPool(t *testing.T, size int, acquireTimeout time.Duration) (*ldapPool, *atomic.Int32) {
+	t.Helper()
+	dials := new(atomic.Int32)
+	dial := func() (*ldap.Conn, error) {
+		dials.Add(1)
+		client, server := net.Pipe()
+		go io.Copy(io.Discard, server)
+		conn := ldap.NewConn(client, false)
+		conn.Start()
+		t.Cleanup(func() {
+			conn.Close()
+			server.Close()
+		})
+		return conn, nil
+	}
+	return newLDAPPool(size, acquireTimeout, 0, 0, dial), dials
+}
+
+func TestLDAPPoolReuse(t *testing.T) {
+	pool, dials := newTestPool(t, 2, time.Second)
+	conn, err := pool.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.put(conn, nil)
+	again, err := pool.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != conn || dials.Load() != 1 {
+		t.Errorf("idle connection not reused, dials = %d", dials.Load())
+	}
+	pool.put(again, nil)
+}
+
+func TestLDAPPoolAcquireTimeout(t *testing.T) {
+	pool, _ := newTestPool(t, 1, 50*time.Millisecond)
+	conn, err := pool.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := pool.get(); err != errPoolTimeout {
+		t.Fatalf("get on full pool = %v, want errPoolTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("get waited %v, want about 50ms", elapsed)
+	}
+
+	// 超时不占用名额，归还后可以再次取出
+	pool.put(conn, nil)
+	conn, err = pool.get()
+	if err != nil {
+		t.Fatalf("get after put = %v", err)
+	}
+	pool.put(conn, nil)
+}
+
+func TestLDAPPoolDiscardBroken(t *testing.T) {
+	pool, dials := newTestPool(t, 1, time.Second)
+
+	// 操作返回网络错误的连接不放回池中
+	conn, _ := pool.get()
+	pool.put(conn, ldap.NewError(ldap.ErrorNetwork, errors.New("connection reset")))
+	if !conn.IsClosing() {
+		t.Error("broken connection not closed")
+	}
+	if len(pool.idle) != 0 {
+		t.Errorf("idle = %d, want 0", len(pool.idle))
+	}
+
+	// 其他错误不影响连接复用
+	conn, _ = pool.get()
+	pool.put(conn, ldap.NewError(ldap.LDAPResultNoSuchObject, errors.New("no such object")))
+	if len(pool.idle) != 1 {
+		t.Fatalf("idle = %d, want 1", len(pool.idle))
+	}
+
+	// 空闲期间断开的连接在取出时丢弃
+	conn.Close()
+	fresh, err := pool.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh == conn || dials.Load() != 3 {
+		t.Errorf("closed idle connection reused, dials = %d", dials.Load())
+	}
+	pool.put(fresh, nil)
+}
+
+func TestLDAPPoolClose(t *testing.T) {
+	pool, _ := newTestPool(t, 2, 0)
+	inUse, _ := pool.get()
+	idle, _ := pool.get()
+	pool.put(idle, nil)
+
+	pool.close()
+	// 空闲连接立即关闭，使用中的连接在归还时关闭
+	if !idle.IsClosing() {
+		t.Error("idle connection not closed")
+	}
+	if inUse.IsClosing() {
+		t.Error("in-use connection closed before put")
+	}
+	pool.put(inUse, nil)
+	if !inUse.IsClosing() {
+		t.Error("returned connection not closed")
+	}
+	if _, err := pool.get(); err != errPoolClosed {
+		t.Errorf("get after close = %v, want errPoolClosed", err)
+	}
+	// 重复关闭不会 panic
+	pool.close()
+}
+
+// 池满时等待中的请求在关闭后立即返回
+func TestLDAPPoolCloseWakesWaiters(t *testing.T) {
+	pool, _ := newTestPool(t, 1, 0)
+	conn, _ := pool.get()
+	result := make(chan error, 1)
+	go func() {
+		_, err := pool.get()
+		result <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	pool.close()
+	select {
+	case err := <-result:
+		if err != errPoolClosed {
+			t.Errorf("waiting get = %v, want errPoolClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiting get not woken by close")
+	}
+	pool.put(conn, nil)
+}
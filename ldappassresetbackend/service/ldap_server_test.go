@@ -0,0 +1,172 @@
+package service
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// fakeEntry 测试目录中的用户，bindData 非空时即使密码正确也以该 AD 子错误码拒绝绑定
+type fakeEntry struct {
+	password string
+	bindData string
+}
+
+// fakeDirectory 最小化的 AD 模拟服务，只支持简单绑定、搜索和修改 unicodePwd
+type fakeDirectory struct {
+	mu         sync.Mutex
+	admin      string
+	password   string
+	users      map[string]*fakeEntry
+	modifiedBy []string // 每次成功修改时连接的绑定身份
+}
+
+// 启动模拟服务并返回连接到该服务的 LDAPService
+func newFakeDirectory(t *testing.T, flavor string, users map[string]*fakeEntry) (*fakeDirectory, *LDAPService) {
+	t.Helper()
+	d := &fakeDirectory{admin: "CN=svc,DC=example,DC=com", password: "svc-secret", users: users}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	s := &LDAPService{
+		host:          "ldap://127.0.0.1",
+		port:          port,
+		baseDn:        "DC=example,DC=com",
+		adminUser:     d.admin,
+		adminPassword: d.password,
+		loginAttrs:    defaultLoginAttrs,
+		userFilter:    buildUserFilter(defaultLoginAttrs, "", ""),
+		flavor:        flavor,
+	}
+	s.pool = newLDAPPool(2, time.Second, 0, 0, s.connect)
+	t.Cleanup(s.pool.close)
+	return d, s
+}
+
+func (d *fakeDirectory) serve(conn net.Conn) {
+	defer conn.Close()
+	bound := ""
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn, password := op.Children[1].Data.String(), op.Children[2].Data.String()
+			code, diag := d.bind(dn, password)
+			if code == ldap.LDAPResultSuccess {
+				bound = dn
+			}
+			conn.Write(fakeResult(id, ldap.ApplicationBindResponse, code, diag).Bytes())
+		case ldap.ApplicationSearchRequest:
+			// 忽略过滤条件，返回目录中的全部用户
+			d.mu.Lock()
+			for dn := range d.users {
+				entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Entry")
+				entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "DN"))
+				entry.AppendChild(ber.NewSequence("Attributes"))
+				conn.Write(fakeEnvelope(id, entry).Bytes())
+			}
+			d.mu.Unlock()
+			conn.Write(fakeResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess, "").Bytes())
+		case ldap.ApplicationModifyRequest:
+			code, diag := d.modify(bound, op)
+			conn.Write(fakeResult(id, ldap.ApplicationModifyResponse, code, diag).Bytes())
+		default:
+			return
+		}
+	}
+}
+
+func (d *fakeDirectory) bind(dn, password string) (uint16, string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	const diag = "80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data %s, v4563"
+	if dn == d.admin && password == d.password {
+		return ldap.LDAPResultSuccess, ""
+	}
+	user, ok := d.users[dn]
+	if !ok || user.password != password {
+		return ldap.LDAPResultInvalidCredentials, strings.Replace(diag, "%s", "52e", 1)
+	}
+	if user.bindData != "" {
+		return ldap.LDAPResultInvalidCredentials, strings.Replace(diag, "%s", user.bindData, 1)
+	}
+	return ldap.LDAPResultSuccess, ""
+}
+
+// 按 AD 修改密码的语义处理：删除的旧值必须与当前密码一致
+func (d *fakeDirectory) modify(bound string, op *ber.Packet) (uint16, string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if bound == "" {
+		return ldap.LDAPResultInsufficientAccessRights, ""
+	}
+	user, ok := d.users[op.Children[0].Data.String()]
+	if !ok {
+		return ldap.LDAPResultNoSuchObject, ""
+	}
+	var oldValue, newValue string
+	for _, change := range op.Children[1].Children {
+		attr := change.Children[1]
+		if attr.Children[0].Data.String() != "unicodePwd" || len(attr.Children[1].Children) != 1 {
+			return ldap.LDAPResultUnwillingToPerform, ""
+		}
+		value := attr.Children[1].Children[0].Data.String()
+		switch change.Children[0].Value {
+		case int64(ldap.DeleteAttribute):
+			oldValue = value
+		case int64(ldap.AddAttribute):
+			newValue = value
+		}
+	}
+	current, _ := encodeADPassword(user.password)
+	if oldValue != current {
+		return ldap.LDAPResultConstraintViolation, "00000056: AtrErr: DSID-03190F80, #1:\n\t0: 00000056: DSID-03190F80, problem 1005 (CONSTRAINT_ATT_TYPE), data 0, Att 9005a (unicodePwd)"
+	}
+	decoded, err := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder().String(newValue)
+	if err != nil {
+		return ldap.LDAPResultConstraintViolation, ""
+	}
+	user.password = strings.Trim(decoded, `"`)
+	user.bindData = ""
+	d.modifiedBy = append(d.modifiedBy, bound)
+	return ldap.LDAPResultSuccess, ""
+}
+
+func fakeEnvelope(id any, op *ber.Packet) *ber.Packet {
+	packet := ber.NewSequence("LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	packet.AppendChild(op)
+	return packet
+}
+
+func fakeResult(id any, tag ber.Tag, code uint16, diag string) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "ResultCode"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "MatchedDN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, diag, "Diagnostic"))
+	return fakeEnvelope(id, result)
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		wantErr  string
+	}{
+		{"plain", "alice", ""},
+		{"mail", "alice@example.com", ""},
+		{"filter injection", "alice)(cn=*", "invalid username"},
+		{"parentheses", "a(b)c", ""},
+		{"backslash", `corp\alice`, ""},
+		{"wildcard", "*", "invalid username"},
+		{"wildcard suffix", "adm*", "invalid username"},
+		{"nul", "alice\x00", "invalid username"},
+		{"newline", "alice\n", "invalid username"},
+		{"del", "alice\x7f", "invalid username"},
+		{"empty", "", "user not found"},
+		{"blank", "   ", "user not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateUsername(tt.username)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateUsername(%q) = %v, want nil", tt.username, err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("validateUsername(%q) = %v, want %q", tt.username, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUserSearchFilter(t *testing.T) {
+	s := &LDAPService{userFilter: buildUserFilter([]string{"sAMAccountName"}, "", "mail")}
+	tests := []struct {
+		name     string
+		username string
+		want     string
+	}{
+		{"plain", "alice", "(|(sAMAccountName=alice)(mail=alice))"},
+		{"wildcard", "*", `(|(sAMAccountName=\2a)(mail=\2a))`},
+		{"parentheses", "a)(cn=b", `(|(sAMAccountName=a\29\28cn=b)(mail=a\29\28cn=b))`},
+		{"backslash", `corp\alice`, `(|(sAMAccountName=corp\5calice)(mail=corp\5calice))`},
+		{"nul", "a\x00", `(|(sAMAccountName=a\00)(mail=a\00))`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.userSearchFilter(tt.username)
+			if got != tt.want {
+				t.Errorf("userSearchFilter(%q) = %q, want %q", tt.username, got, tt.want)
+			}
+			// 转义后的过滤器必须仍能被正确解析，不会改变过滤器结构
+			if _, err := ldap.CompileFilter(got); err != nil {
+				t.Errorf("CompileFilter(%q): %v", got, err)
+			}
+		})
+	}
+}
+
+func TestSingleUserEntry(t *testing.T) {
+	alice := ldap.NewEntry("cn=alice,dc=example,dc=com", nil)
+	bob := ldap.NewEntry("cn=bob,dc=example,dc=com", nil)
+	sizeLimit := ldap.NewError(ldap.LDAPResultSizeLimitExceeded, errors.New("size limit exceeded"))
+
+	tests := []struct {
+		name    string
+		sr      *ldap.SearchResult
+		err     error
+		wantDN  string
+		wantErr string
+	}{
+		{"single", &ldap.SearchResult{Entries: []*ldap.Entry{alice}}, nil, alice.DN, ""},
+		{"none", &ldap.SearchResult{}, nil, "", "user not found"},
+		{"nil result", nil, nil, "", "user not found"},
+		{"search error", nil, errors.New("connection reset"), "", "user not found"},
+		{"multiple", &ldap.SearchResult{Entries: []*ldap.Entry{alice, bob}}, nil, "", "multiple users matched"},
+		{"size limit", &ldap.SearchResult{Entries: []*ldap.Entry{alice, bob}}, sizeLimit, "", "multiple users matched"},
+		{"size limit single", &ldap.SearchResult{Entries: []*ldap.Entry{alice}}, sizeLimit, "", "multiple users matched"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := singleUserEntry(tt.sr, tt.err)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("singleUserEntry() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("singleUserEntry() error = %v", err)
+			}
+			if entry.DN != tt.wantDN {
+				t.Errorf("singleUserEntry() DN = %q, want %q", entry.DN, tt.wantDN)
+			}
+		})
+	}
+}
+
+func TestBindError(t *testing.T) {
+	const diag = "80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data %s, v4563"
+	bindErr := func(data string) error {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New(strings.Replace(diag, "%s", data, 1)))
+	}
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"wrong password", bindErr("52e"), ErrInvalidCredentials},
+		{"password expired", bindErr("532"), ErrPasswordExpired},
+		{"must change", bindErr("773"), ErrPasswordExpired},
+		// 账户锁定或禁用时不透露密码是否正确
+		{"locked", bindErr("775"), ErrInvalidCredentials},
+		{"openldap", ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("")), ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bindError(tt.err); got != tt.want {
+				t.Errorf("bindError = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	other := ldap.NewError(ldap.LDAPResultBusy, errors.New("busy"))
+	if got := bindError(other); got != other {
+		t.Errorf("bindError(busy) = %v, want unchanged", got)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	const dn = "CN=Alice,DC=example,DC=com"
+	tests := []struct {
+		name      string
+		flavor    string
+		bindData  string
+		old       string
+		wantErr   error
+		changedBy string // 空字符串表示密码未修改
+	}{
+		{"bind as user", flavorAD, "", "old-Pass1", nil, dn},
+		{"wrong password", flavorAD, "", "guess", ErrInvalidCredentials, ""},
+		// 过期或须修改密码的账户无法绑定，由服务账号提交修改，DC 仍校验旧密码
+		{"password expired", flavorAD, adBindPasswordExpired, "old-Pass1", nil, "CN=svc,DC=example,DC=com"},
+		{"must change", flavorAD, adBindMustChange, "old-Pass1", nil, "CN=svc,DC=example,DC=com"},
+		{"expired wrong password", flavorAD, adBindPasswordExpired, "guess", ErrInvalidCredentials, ""},
+		{"expired on openldap", flavorOpenLDAP, adBindPasswordExpired, "old-Pass1", ErrPasswordExpired, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &fakeEntry{password: "old-Pass1", bindData: tt.bindData}
+			d, s := newFakeDirectory(t, tt.flavor, map[string]*fakeEntry{dn: user})
+
+			err := s.ChangePassword("alice", tt.old, "n3w-Pass!")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ChangePassword = %v, want %v", err, tt.wantErr)
+			}
+			if tt.changedBy == "" {
+				if len(d.modifiedBy) != 0 || user.password != "old-Pass1" {
+					t.Errorf("password changed by %v", d.modifiedBy)
+				}
+				return
+			}
+			if len(d.modifiedBy) != 1 || d.modifiedBy[0] != tt.changedBy {
+				t.Errorf("modified by %v, want %s", d.modifiedBy, tt.changedBy)
+			}
+			if user.password != "n3w-Pass!" || user.bindData != "" {
+				t.Errorf("password = %q, bindData = %q", user.password, user.bindData)
+			}
+		})
+	}
+}
+
+// 服务账号连接上旧密码不匹配时同样返回 ErrInvalidCredentials
+func TestIsADWrongPassword(t *testing.T) {
+	wrong := ldap.NewError(ldap.LDAPResultConstraintViolation, errors.New("00000056: AtrErr: DSID-03190F80, #1:"))
+	policy := ldap.NewError(ldap.LDAPResultConstraintViolation, errors.New("0000052D: Constraint violation - check_password_restrictions"))
+	if !isADWrongPassword(wrong) {
+		t.Error("wrong old password not detected")
+	}
+	if isADWrongPassword(policy) {
+		t.Error("policy violation reported as wrong password")
+	}
+}
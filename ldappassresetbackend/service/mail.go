@@ -13,6 +13,7 @@ import (
 	"gopkg.in/gomail.v2"
 
 	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gctx"
 )
 
 type EmailService struct {
@@ -51,6 +52,28 @@ func NewEmailService() *EmailService {
 }
 
 func (s *EmailService) SendEmail(user, email, code string) error {
+	return s.send(email, s.subject, s.emailTemplate, struct {
+		User string
+		Code string
+	}{
+		User: user,
+		Code: code,
+	})
+}
+
+// SendPasswordChangedNotice 发送密码已修改通知，模板和标题取自 notify 配置
+func (s *EmailService) SendPasswordChangedNotice(email string, notice PasswordChangedNotice) error {
+	cfg := g.Cfg().MustGet(context.TODO(), "notify").Map()
+	subject, _ := cfg["emailSubject"].(string)
+	emailTemplate, _ := cfg["emailTemplate"].(string)
+	if subject == "" {
+		subject = "您的密码已被修改"
+	}
+	return s.send(email, subject, emailTemplate, notice)
+}
+
+// 按模板渲染邮件内容并发送
+func (s *EmailService) send(email, subject, emailTemplate string, data interface{}) error {
 	// 发件人UTF-8编码
 	parsedSender, err := ParseSender(s.sender)
 	if err != nil {
@@ -60,20 +83,14 @@ func (s *EmailService) SendEmail(user, email, code string) error {
 
 	m.SetHeader("From", parsedSender)
 	m.SetHeader("To", email)
-	m.SetHeader("Subject", s.subject)
+	m.SetHeader("Subject", subject)
 	// 创建模板，进行内容替换
-	tmpl, err := template.New("email").Parse(s.emailTemplate)
+	tmpl, err := template.New("email").Parse(emailTemplate)
 	if err != nil {
 		return fmt.Errorf("template parsing failed: %v", err)
 	}
 	var emailContent bytes.Buffer
-	err = tmpl.Execute(&emailContent, struct {
-		User string
-		Code string
-	}{
-		User: user,
-		Code: code,
-	})
+	err = tmpl.Execute(&emailContent, data)
 	if err != nil {
 		return fmt.Errorf("template replacement failed: %v", err)
 	}
@@ -82,7 +99,7 @@ func (s *EmailService) SendEmail(user, email, code string) error {
 
 	port, err := strconv.Atoi(s.port)
 	if err != nil {
-		fmt.Println("Error converting port:", err)
+		g.Log().Error(gctx.New(), "Error converting port:", err)
 		return nil
 	}
 	d := gomail.NewDialer(s.address, port, s.username, s.password)
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/os/gctx"
+)
+
+// 多因素策略：按用户所属的组要求在同一重置会话中通过多种验证方式，已通过的方式记录在重置令牌中
+
+// ErrFactorsRequired 当前令牌未满足用户所属组要求的全部验证方式
+var ErrFactorsRequired = errors.New("additional verification required")
+
+// mfaPolicy 属于 groups 中任一组的用户需要满足 factors 中的每一项，每一项内的验证方式任选其一
+type mfaPolicy struct {
+	Name    string     `json:"name"`
+	Groups  []string   `json:"groups"`
+	Factors [][]string `json:"factors"`
+}
+
+var (
+	mfaOnce     sync.Once
+	mfaEnabled  bool
+	mfaPolicies []mfaPolicy
+	mfaErr      error
+)
+
+func loadMFAConfig() error {
+	mfaOnce.Do(func() {
+		ctx := context.TODO()
+		mfaEnabled = g.Cfg().MustGet(ctx, "mfa.enabled", false).Bool()
+		if !mfaEnabled {
+			return
+		}
+		if err := g.Cfg().MustGet(ctx, "mfa.policies").Scan(&mfaPolicies); err != nil {
+			mfaErr = fmt.Errorf("invalid mfa.policies: %v", err)
+			return
+		}
+		for _, p := range mfaPolicies {
+			if len(p.Groups) == 0 || len(p.Factors) == 0 {
+				mfaErr = fmt.Errorf("mfa policy %q must have groups and factors", p.Name)
+				return
+			}
+			for _, alternatives := range p.Factors {
+				if len(alternatives) == 0 {
+					mfaErr = fmt.Errorf("mfa policy %q has an empty factor", p.Name)
+					return
+				}
+			}
+		}
+	})
+	return mfaErr
+}
+
+// 用户需要满足的验证方式，每一项内任选其一，命中多条策略时合并全部要求
+func requiredFactors(user *LDAPUser) [][]string {
+	if err := loadMFAConfig(); err != nil {
+		// 配置错误时不能放行特权账户，要求一个不存在的验证方式使重置无法完成
+		g.Log().Error(gctx.New(), "invalid mfa config:", err)
+		return [][]string{{"unavailable"}}
+	}
+	if !mfaEnabled {
+		return nil
+	}
+	var required [][]string
+	for _, p := range mfaPolicies {
+		for _, group := range p.Groups {
+			if user.memberOf(group) {
+				required = append(required, p.Factors...)
+				break
+			}
+		}
+	}
+	return required
+}
+
+// pendingFactors 返回尚未满足的项，为空表示已满足用户的多因素策略
+func pendingFactors(user *LDAPUser, satisfied []string) [][]string {
+	done := make(map[string]bool, len(satisfied))
+	for _, f := range satisfied {
+		done[f] = true
+	}
+	pending := [][]string{}
+	for _, alternatives := range requiredFactors(user) {
+		ok := false
+		for _, f := range alternatives {
+			if done[f] {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			pending = append(pending, alternatives)
+		}
+	}
+	return pending
+}
+
+// 合并已通过的验证方式，去重并保持顺序
+func mergeFactors(satisfied []string, factor string) []string {
+	for _, f := range satisfied {
+		if f == factor {
+			return satisfied
+		}
+	}
+	return append(append([]string{}, satisfied...), factor)
+}
+
+// 校验请求中本次会话之前签发的令牌，不消费令牌，未带令牌时返回 nil
+// 在校验验证码之前调用，避免令牌无效时白白用掉验证码
+func priorResetToken(r *ghttp.Request, user *LDAPUser) (*resetClaims, error) {
+	if r.Get("token").String() == "" {
+		return nil, nil
+	}
+	return checkResetToken(r, user)
+}
+
+// 计算新令牌中的验证方式：有之前的令牌时累计其中已通过的方式，
+// 旧令牌必须成功作废才能累计，防止重放已使用过的令牌凑齐验证方式
+func carryOverFactors(prev *resetClaims, factor string) ([]string, error) {
+	if prev == nil {
+		return []string{factor}, nil
+	}
+	if err := consumeResetToken(prev); err != nil {
+		return nil, err
+	}
+	return mergeFactors(prev.factors(), factor), nil
+}
+
+// 校验令牌是否满足用户的多因素策略
+func checkFactors(user *LDAPUser, claims *resetClaims) error {
+	if len(pendingFactors(user, claims.factors())) > 0 {
+		return ErrFactorsRequired
+	}
+	return nil
+}
+
+// 绑定验证方式时令牌校验失败的返回码
+func enrollTokenErrorCode(err error) int {
+	if errors.Is(err, ErrFactorsRequired) {
+		return 10027
+	}
+	return 10018
+}
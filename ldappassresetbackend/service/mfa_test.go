@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/util/guid"
+)
+
+// 启用多因素策略：Domain Admins 需要短信或 TOTP，外加邮箱
+func setupMFA(t *testing.T) {
+	t.Helper()
+	setTestConfig(t, `{
+		"mfa": {
+			"enabled": true,
+			"policies": [{"name": "admins", "groups": ["CN=Domain Admins,CN=Users,DC=example,DC=com"], "factors": [["mobile", "totp"], ["mail"]]}]
+		}
+	}`)
+	mfaOnce = sync.Once{}
+	t.Cleanup(func() { mfaOnce = sync.Once{} })
+}
+
+func TestCheckFactors(t *testing.T) {
+	setupMFA(t)
+	admin := &LDAPUser{DN: "cn=admin,dc=example,dc=com", Groups: []string{"cn=domain admins,cn=users,dc=example,dc=com"}}
+	user := &LDAPUser{DN: "cn=alice,dc=example,dc=com"}
+
+	tests := []struct {
+		name    string
+		user    *LDAPUser
+		factors []string
+		pending int
+	}{
+		{"regular user", user, []string{"mail"}, 0},
+		{"admin single factor", admin, []string{"mobile"}, 1},
+		{"admin alternatives only", admin, []string{"mobile", "totp"}, 1},
+		{"admin all factors", admin, []string{"totp", "mail"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := &resetClaims{Channel: tt.factors[0], Factors: tt.factors}
+			if got := pendingFactors(tt.user, claims.factors()); len(got) != tt.pending {
+				t.Errorf("pendingFactors = %v, want %d pending", got, tt.pending)
+			}
+			err := checkFactors(tt.user, claims)
+			if (err != nil) != (tt.pending > 0) {
+				t.Errorf("checkFactors = %v", err)
+			}
+		})
+	}
+}
+
+// 启动测试服务：/issue 签发令牌，/carry 按请求中的令牌累计验证方式
+func newFactorTestServer(t *testing.T, user *LDAPUser) string {
+	t.Helper()
+	s := g.Server(guid.S())
+	s.SetAddr("127.0.0.1:0")
+	s.SetDumpRouterMap(false)
+	s.SetLogStdout(false)
+	s.BindHandler("/issue", func(r *ghttp.Request) {
+		token, err := IssueResetToken(r, user.DN, r.Get("channel").String(), []string{r.Get("channel").String()})
+		if err != nil {
+			r.Response.WriteStatusExit(500, err.Error())
+		}
+		r.Response.Write(token)
+	})
+	s.BindHandler("/carry", func(r *ghttp.Request) {
+		prev, err := priorResetToken(r, user)
+		if err != nil {
+			r.Response.WriteExit("error: " + err.Error())
+		}
+		// check 为真时只校验不累计，模拟验证码校验失败
+		if r.Get("check").Bool() {
+			r.Response.WriteExit("checked")
+		}
+		factors, err := carryOverFactors(prev, r.Get("channel").String())
+		if err != nil {
+			r.Response.WriteExit("error: " + err.Error())
+		}
+		r.Response.Write(strings.Join(factors, ","))
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Shutdown() })
+	time.Sleep(50 * time.Millisecond)
+	return fmt.Sprintf("http://127.0.0.1:%d", s.GetListenedPort())
+}
+
+func TestCarryOverFactors(t *testing.T) {
+	user := &LDAPUser{DN: "cn=admin,dc=example,dc=com"}
+	base := newFactorTestServer(t, user)
+	ctx := context.Background()
+	client := g.Client().Prefix(base)
+
+	// 不带令牌时只有本次的验证方式
+	if got := client.PostContent(ctx, "/carry", g.Map{"channel": "mail"}); got != "mail" {
+		t.Errorf("carry without token = %q", got)
+	}
+
+	token := client.PostContent(ctx, "/issue", g.Map{"channel": "mobile"})
+	// 只校验旧令牌不会使其作废
+	if got := client.PostContent(ctx, "/carry", g.Map{"channel": "mail", "token": token, "check": true}); got != "checked" {
+		t.Fatalf("check token = %q", got)
+	}
+	if got := client.PostContent(ctx, "/carry", g.Map{"channel": "mail", "token": token}); got != "mobile,mail" {
+		t.Fatalf("carry with token = %q, want mobile,mail", got)
+	}
+	// 旧令牌已作废，重放时不能再累计
+	if got := client.PostContent(ctx, "/carry", g.Map{"channel": "totp", "token": token}); got != "error: "+ErrInvalidToken.Error() {
+		t.Errorf("carry with replayed token = %q, want invalid token", got)
+	}
+	// 伪造的令牌同样拒绝
+	if got := client.PostContent(ctx, "/carry", g.Map{"channel": "totp", "token": token + "x"}); got != "error: "+ErrInvalidToken.Error() {
+		t.Errorf("carry with forged token = %q, want invalid token", got)
+	}
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/os/gctx"
+)
+
+// PasswordChangedNotice 密码修改通知的模板变量
+type PasswordChangedNotice struct {
+	User     string // 显示名称
+	Username string // 登录名
+	Action   string // 重置密码或修改密码
+	Time     string // 修改时间
+	IP       string // 客户端 IP
+	Helpdesk string // 非本人操作时的联系方式
+}
+
+// notifyPasswordChanged 密码修改成功后异步通知用户的全部联系方式，发送失败只记录日志
+func notifyPasswordChanged(r *ghttp.Request, user *LDAPUser, action string) {
+	ctx := context.TODO()
+	if user == nil || user.fake || !g.Cfg().MustGet(ctx, "notify.enabled", true).Bool() {
+		return
+	}
+
+	// 请求结束后不能再访问 r，需要的信息在启动协程前取出
+	notice := PasswordChangedNotice{
+		User:     user.Name,
+		Username: user.Username,
+		Action:   action,
+		Time:     time.Now().Format("2006-01-02 15:04:05"),
+		IP:       clientIP(r),
+		Helpdesk: g.Cfg().MustGet(ctx, "notify.helpdesk").String(),
+	}
+	if notice.User == "" {
+		notice.User = user.Username
+	}
+	mail, mobile := user.Mail, user.Mobile
+
+	go func() {
+		if mail != "" {
+			if err := NewEmailService().SendPasswordChangedNotice(mail, notice); err != nil {
+				g.Log().Error(gctx.New(), "failed to send password changed email:", err)
+			}
+		}
+		if mobile != "" {
+			if err := SendPasswordChangedSms(mobile, notice); err != nil {
+				g.Log().Error(gctx.New(), "failed to send password changed sms:", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,312 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/os/gctx"
+)
+
+// PasswordPolicy 密码策略，由配置文件和域策略合并而来
+type PasswordPolicy struct {
+	MinLength           int      `json:"minLength"`           // 最小长度
+	MaxLength           int      `json:"maxLength"`           // 最大长度，0 表示不限制
+	MinLetters          int      `json:"minLetters"`          // 最少字母个数
+	MinUpper            int      `json:"minUpper"`            // 最少大写字母个数
+	MinLower            int      `json:"minLower"`            // 最少小写字母个数
+	MinDigits           int      `json:"minDigits"`           // 最少数字个数
+	MinSymbols          int      `json:"minSymbols"`          // 最少特殊字符个数
+	MinClasses          int      `json:"minClasses"`          // 大写、小写、数字、特殊字符中至少包含几类
+	DisallowUsername    bool     `json:"disallowUsername"`    // 不能包含用户名
+	DisallowDisplayName bool     `json:"disallowDisplayName"` // 不能包含显示名称中长度不小于 3 的片段
+	BannedSubstrings    []string `json:"bannedSubstrings"`    // 不能包含的字符串，不区分大小写
+}
+
+// 域策略缓存时间
+const domainPolicyCacheDuration = 10 * time.Minute
+
+// pwdProperties 中的密码复杂度标志
+const domainPasswordComplex = 0x1
+
+var (
+	configPolicy     *PasswordPolicy
+	configPolicyOnce sync.Once
+
+	domainPolicyMu      sync.Mutex
+	domainPolicy        *PasswordPolicy
+	domainPolicyExpires time.Time
+)
+
+// 从配置文件读取密码策略，未配置时与原有规则保持一致
+func getConfigPolicy() *PasswordPolicy {
+	configPolicyOnce.Do(func() {
+		ctx := context.TODO()
+		configPolicy = &PasswordPolicy{
+			MinLength:           g.Cfg().MustGet(ctx, "passwordPolicy.minLength", 8).Int(),
+			MaxLength:           g.Cfg().MustGet(ctx, "passwordPolicy.maxLength", 128).Int(),
+			MinLetters:          g.Cfg().MustGet(ctx, "passwordPolicy.minLetters", 1).Int(),
+			MinUpper:            g.Cfg().MustGet(ctx, "passwordPolicy.minUpper", 0).Int(),
+			MinLower:            g.Cfg().MustGet(ctx, "passwordPolicy.minLower", 0).Int(),
+			MinDigits:           g.Cfg().MustGet(ctx, "passwordPolicy.minDigits", 1).Int(),
+			MinSymbols:          g.Cfg().MustGet(ctx, "passwordPolicy.minSymbols", 0).Int(),
+			MinClasses:          g.Cfg().MustGet(ctx, "passwordPolicy.minClasses", 0).Int(),
+			DisallowUsername:    g.Cfg().MustGet(ctx, "passwordPolicy.disallowUsername", true).Bool(),
+			DisallowDisplayName: g.Cfg().MustGet(ctx, "passwordPolicy.disallowDisplayName", true).Bool(),
+			BannedSubstrings:    g.Cfg().MustGet(ctx, "passwordPolicy.bannedSubstrings").Strings(),
+		}
+	})
+	return configPolicy
+}
+
+// 是否从域中读取 minPwdLength、pwdProperties 及细粒度密码策略
+func policyFromDomain() bool {
+	return g.Cfg().MustGet(context.TODO(), "passwordPolicy.fromDomain", false).Bool()
+}
+
+// GetPasswordPolicy 返回当前生效的密码策略，user 不为空时合并该用户的细粒度密码策略(PSO)
+func GetPasswordPolicy(user *LDAPUser) *PasswordPolicy {
+	policy := *getConfigPolicy()
+	if !policyFromDomain() {
+		return &policy
+	}
+
+	ldapService, err := GetLDAPService()
+	if err != nil {
+		g.Log().Warning(gctx.New(), "failed to load domain password policy:", err)
+		return &policy
+	}
+
+	// 用户应用了 PSO 时以 PSO 为准，否则使用域默认策略
+	var remote *PasswordPolicy
+	if user != nil && user.PSO != "" {
+		remote, err = ldapService.readPSOPolicy(user.PSO)
+	} else {
+		remote, err = ldapService.cachedDomainPolicy()
+	}
+	if err != nil {
+		g.Log().Warning(gctx.New(), "failed to load domain password policy:", err)
+		return &policy
+	}
+	policy.merge(remote)
+	return &policy
+}
+
+// 合并域策略，取两者中更严格的值
+func (p *PasswordPolicy) merge(other *PasswordPolicy) {
+	if other.MinLength > p.MinLength {
+		p.MinLength = other.MinLength
+	}
+	if other.MinClasses > p.MinClasses {
+		p.MinClasses = other.MinClasses
+	}
+	p.DisallowUsername = p.DisallowUsername || other.DisallowUsername
+	p.DisallowDisplayName = p.DisallowDisplayName || other.DisallowDisplayName
+}
+
+func (s *LDAPService) cachedDomainPolicy() (*PasswordPolicy, error) {
+	domainPolicyMu.Lock()
+	defer domainPolicyMu.Unlock()
+
+	if domainPolicy != nil && time.Now().Before(domainPolicyExpires) {
+		return domainPolicy, nil
+	}
+	policy, err := s.readDomainPolicy()
+	if err != nil {
+		return nil, err
+	}
+	domainPolicy = policy
+	domainPolicyExpires = time.Now().Add(domainPolicyCacheDuration)
+	return policy, nil
+}
+
+// 从域根读取默认密码策略
+func (s *LDAPService) readDomainPolicy() (*PasswordPolicy, error) {
+	var entry *ldap.Entry
+	err := s.withConn(func(conn *ldap.Conn) error {
+		// 通过 RootDSE 找到域根
+		rootDSE, err := conn.Search(ldap.NewSearchRequest(
+			"", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false,
+			"(objectClass=*)", []string{"defaultNamingContext"}, nil,
+		))
+		if err != nil {
+			return err
+		}
+		domainDN := s.baseDn
+		if len(rootDSE.Entries) > 0 {
+			if dn := rootDSE.Entries[0].GetAttributeValue("defaultNamingContext"); dn != "" {
+				domainDN = dn
+			}
+		}
+
+		sr, err := conn.Search(ldap.NewSearchRequest(
+			domainDN, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false,
+			"(objectClass=*)", []string{"minPwdLength", "pwdProperties"}, nil,
+		))
+		if err != nil {
+			return err
+		}
+		if len(sr.Entries) == 0 {
+			return fmt.Errorf("domain root not found")
+		}
+		entry = sr.Entries[0]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &PasswordPolicy{
+		MinLength: attributeInt(entry, "minPwdLength"),
+	}
+	if attributeInt(entry, "pwdProperties")&domainPasswordComplex != 0 {
+		setComplexity(policy)
+	}
+	return policy, nil
+}
+
+// 读取细粒度密码策略对象
+func (s *LDAPService) readPSOPolicy(psoDN string) (*PasswordPolicy, error) {
+	var entry *ldap.Entry
+	err := s.withConn(func(conn *ldap.Conn) error {
+		sr, err := conn.Search(ldap.NewSearchRequest(
+			psoDN, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false,
+			"(objectClass=*)", []string{"msDS-MinimumPasswordLength", "msDS-PasswordComplexityEnabled"}, nil,
+		))
+		if err != nil {
+			return err
+		}
+		if len(sr.Entries) == 0 {
+			return fmt.Errorf("password settings object not found")
+		}
+		entry = sr.Entries[0]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &PasswordPolicy{
+		MinLength: attributeInt(entry, "msDS-MinimumPasswordLength"),
+	}
+	if strings.EqualFold(entry.GetAttributeValue("msDS-PasswordComplexityEnabled"), "TRUE") {
+		setComplexity(policy)
+	}
+	return policy, nil
+}
+
+// 读取整数属性，不存在或格式错误时返回 0
+func attributeInt(entry *ldap.Entry, name string) int {
+	v, _ := strconv.Atoi(entry.GetAttributeValue(name))
+	return v
+}
+
+// AD 复杂度要求：四类字符至少包含三类，且不能包含账户名和显示名称
+func setComplexity(policy *PasswordPolicy) {
+	policy.MinClasses = 3
+	policy.DisallowUsername = true
+	policy.DisallowDisplayName = true
+}
+
+// Validate 按策略校验密码，返回第一条不满足的规则
+func (p *PasswordPolicy) Validate(password string, user *LDAPUser) error {
+	length := len([]rune(password))
+	if length < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return fmt.Errorf("password must be at most %d characters", p.MaxLength)
+	}
+
+	var letters, upper, lower, digits, symbols int
+	for _, c := range password {
+		switch {
+		case unicode.IsSpace(c) || unicode.IsControl(c):
+			return fmt.Errorf("password must not contain whitespace or control characters")
+		case unicode.IsUpper(c):
+			letters++
+			upper++
+		case unicode.IsLower(c):
+			letters++
+			lower++
+		case unicode.IsLetter(c):
+			letters++
+		case unicode.IsDigit(c):
+			digits++
+		default:
+			symbols++
+		}
+	}
+	if letters < p.MinLetters {
+		return fmt.Errorf("password must contain at least %d letters", p.MinLetters)
+	}
+	if upper < p.MinUpper {
+		return fmt.Errorf("password must contain at least %d uppercase letters", p.MinUpper)
+	}
+	if lower < p.MinLower {
+		return fmt.Errorf("password must contain at least %d lowercase letters", p.MinLower)
+	}
+	if digits < p.MinDigits {
+		return fmt.Errorf("password must contain at least %d digits", p.MinDigits)
+	}
+	if symbols < p.MinSymbols {
+		return fmt.Errorf("password must contain at least %d symbols", p.MinSymbols)
+	}
+
+	classes := 0
+	for _, n := range []int{upper, lower, digits, symbols} {
+		if n > 0 {
+			classes++
+		}
+	}
+	if classes < p.MinClasses {
+		return fmt.Errorf("password must contain at least %d of uppercase, lowercase, digits and symbols", p.MinClasses)
+	}
+
+	lowered := strings.ToLower(password)
+	for _, banned := range p.BannedSubstrings {
+		if banned != "" && strings.Contains(lowered, strings.ToLower(banned)) {
+			return fmt.Errorf("password contains a banned word")
+		}
+	}
+	if user != nil {
+		if p.DisallowUsername && len(user.Username) >= 3 && strings.Contains(lowered, strings.ToLower(user.Username)) {
+			return fmt.Errorf("password must not contain the username")
+		}
+		if p.DisallowDisplayName {
+			// 与 AD 一致，按分隔符拆分显示名称，长度不小于 3 的片段都不能出现在密码中
+			tokens := strings.FieldsFunc(user.Name, func(c rune) bool {
+				return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+			})
+			for _, token := range tokens {
+				if len([]rune(token)) >= 3 && strings.Contains(lowered, strings.ToLower(token)) {
+					return fmt.Errorf("password must not contain the display name")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// 按当前生效的策略校验密码，并检查是否为泄露密码
+func validatePassword(password string, user *LDAPUser) error {
+	if err := GetPasswordPolicy(user).Validate(password, user); err != nil {
+		return err
+	}
+	return checkBreachedPassword(password)
+}
+
+// GetPasswordPolicyInfo 返回当前生效的密码策略，供前端展示规则
+func GetPasswordPolicyInfo(r *ghttp.Request) {
+	r.Response.WriteJsonExit(g.Map{
+		"code":   200,
+		"policy": GetPasswordPolicy(nil),
+	})
+}
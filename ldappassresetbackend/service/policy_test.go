@@ -0,0 +1,116 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := &PasswordPolicy{
+		MinLength:           8,
+		MaxLength:           20,
+		MinLetters:          1,
+		MinDigits:           1,
+		DisallowUsername:    true,
+		DisallowDisplayName: true,
+		BannedSubstrings:    []string{"Passw0rd", "company"},
+	}
+	user := &LDAPUser{Username: "jdoe", Name: "John Doe-Li"}
+
+	tests := []struct {
+		name     string
+		policy   *PasswordPolicy
+		password string
+		want     string // 期望错误包含的内容，空字符串表示通过
+	}{
+		{"ok", policy, "blue-sky_42", ""},
+		{"hyphen and underscore", policy, "a-b_c-d_1", ""},
+		{"unicode letters", policy, "密码安全测试1234", ""},
+		{"too short", policy, "abc12", "at least 8 characters"},
+		{"too long", policy, strings.Repeat("a1", 11), "at most 20 characters"},
+		{"no digits", policy, "abcdefgh", "at least 1 digits"},
+		{"no letters", policy, "12345678", "at least 1 letters"},
+		{"whitespace", policy, "blue sky 42", "whitespace"},
+		{"control", policy, "bluesky\t42", "whitespace"},
+		{"banned substring", policy, "mycompany99", "banned word"},
+		{"banned substring case", policy, "xxPASSW0RDxx", "banned word"},
+		{"username", policy, "JDoe2024!!", "username"},
+		{"display name token", policy, "hello-john-1", "display name"},
+		{"short display name token", policy, "li12345678", ""},
+		{"username too short to check", &PasswordPolicy{MinLength: 1, DisallowUsername: true}, "ab-secret", ""},
+		{"classes", &PasswordPolicy{MinClasses: 3}, "abcdef12", "at least 3 of"},
+		{"classes met", &PasswordPolicy{MinClasses: 3}, "Abcdef12", ""},
+		{"upper", &PasswordPolicy{MinUpper: 2}, "Abcdef12", "uppercase"},
+		{"lower", &PasswordPolicy{MinLower: 1}, "ABCDEF12", "lowercase"},
+		{"symbols", &PasswordPolicy{MinSymbols: 2}, "abc-def1", "symbols"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate(tt.password, user)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("Validate(%q) = %v, want nil", tt.password, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate(%q) = %v, want error containing %q", tt.password, err, tt.want)
+			}
+		})
+	}
+}
+
+// 未指定用户时(如 /api/password-policy)跳过与账户相关的规则
+func TestPasswordPolicyValidateWithoutUser(t *testing.T) {
+	policy := &PasswordPolicy{DisallowUsername: true, DisallowDisplayName: true}
+	if err := policy.Validate("jdoe-john", nil); err != nil {
+		t.Errorf("Validate without user = %v", err)
+	}
+}
+
+func TestPasswordPolicyMerge(t *testing.T) {
+	tests := []struct {
+		name   string
+		local  PasswordPolicy
+		remote *PasswordPolicy
+		want   PasswordPolicy
+	}{
+		{
+			"domain stricter",
+			PasswordPolicy{MinLength: 8, MaxLength: 128, MinDigits: 1},
+			&PasswordPolicy{MinLength: 12},
+			PasswordPolicy{MinLength: 12, MaxLength: 128, MinDigits: 1},
+		},
+		{
+			"config stricter",
+			PasswordPolicy{MinLength: 14, MinClasses: 4},
+			&PasswordPolicy{MinLength: 7, MinClasses: 3},
+			PasswordPolicy{MinLength: 14, MinClasses: 4},
+		},
+		{
+			"domain complexity",
+			PasswordPolicy{MinLength: 8},
+			func() *PasswordPolicy { p := &PasswordPolicy{MinLength: 10}; setComplexity(p); return p }(),
+			PasswordPolicy{MinLength: 10, MinClasses: 3, DisallowUsername: true, DisallowDisplayName: true},
+		},
+		{
+			// PSO 关闭复杂度时不会放宽配置中的要求
+			"pso without complexity",
+			PasswordPolicy{MinLength: 8, MinClasses: 2, DisallowUsername: true, BannedSubstrings: []string{"company"}},
+			&PasswordPolicy{MinLength: 4},
+			PasswordPolicy{MinLength: 8, MinClasses: 2, DisallowUsername: true, BannedSubstrings: []string{"company"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.local
+			got.merge(tt.remote)
+			if got.MinLength != tt.want.MinLength || got.MaxLength != tt.want.MaxLength ||
+				got.MinDigits != tt.want.MinDigits || got.MinClasses != tt.want.MinClasses ||
+				got.DisallowUsername != tt.want.DisallowUsername || got.DisallowDisplayName != tt.want.DisallowDisplayName ||
+				strings.Join(got.BannedSubstrings, ",") != strings.Join(tt.want.BannedSubstrings, ",") {
+				t.Errorf("merge = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// 隐私模式：对不存在的用户返回与真实用户无法区分的响应和耗时，防止枚举账户
+
+var (
+	privacyOnce            sync.Once
+	privacyEnabled         bool
+	privacySecret          []byte
+	privacyMailDomain      string
+	privacyMinResponseTime time.Duration
+
+	mobilePattern = regexp.MustCompile(`^1[3-9]\d{9}$`)
+	mailPattern   = regexp.MustCompile(`^[^@\s]{3,}@[^@\s]+\.[^@\s]+$`)
+)
+
+func loadPrivacyConfig() {
+	privacyOnce.Do(func() {
+		ctx := context.TODO()
+		privacyEnabled = g.Cfg().MustGet(ctx, "privacy.enabled", false).Bool()
+		privacyMinResponseTime = time.Duration(g.Cfg().MustGet(ctx, "privacy.minResponseTime", 800).Int()) * time.Millisecond
+
+		// 未配置密钥时随机生成，多副本部署时应配置相同的值，保证同一输入得到相同的假数据
+		secret := g.Cfg().MustGet(ctx, "privacy.secret").String()
+		if secret != "" {
+			privacySecret = []byte(secret)
+		} else {
+			privacySecret = make([]byte, 32)
+			if _, err := rand.Read(privacySecret); err != nil {
+				panic(err)
+			}
+		}
+
+		// 假邮箱域名，未配置时由 baseDn 的 DC 部分拼出
+		privacyMailDomain = g.Cfg().MustGet(ctx, "privacy.mailDomain").String()
+		if privacyMailDomain == "" {
+			privacyMailDomain = domainFromDN(g.Cfg().MustGet(ctx, "ldap.baseDn").String())
+		}
+	})
+}
+
+func isPrivacyMode() bool {
+	loadPrivacyConfig()
+	return privacyEnabled
+}
+
+// lookupUser 查找用户，隐私模式下找不到用户时返回由输入确定性生成的假用户
+func lookupUser(ldapService *LDAPService, username string) (*LDAPUser, error) {
+	user, err := ldapService.GetUser(username)
+	if err != nil && isPrivacyMode() {
+		return fakeUser(username), nil
+	}
+	return user, err
+}
+
+// 由输入生成假用户，同一输入始终得到相同的联系方式
+func fakeUser(input string) *LDAPUser {
+	mac := hmac.New(sha256.New, privacySecret)
+	mac.Write([]byte(strings.ToLower(input)))
+	sum := mac.Sum(nil)
+
+	user := &LDAPUser{fake: true}
+
+	// 输入本身是手机号或邮箱时直接使用，与真实用户的打码结果一致
+	if mobilePattern.MatchString(input) {
+		user.Mobile = input
+	} else {
+		n := binary.BigEndian.Uint64(sum[0:8])
+		user.Mobile = fmt.Sprintf("1%d%09d", 3+n%7, (n/7)%1000000000)
+	}
+
+	if mailPattern.MatchString(input) {
+		user.Mail = input
+	} else {
+		local := strings.ToLower(input)
+		if len(local) < 3 || strings.ContainsAny(local, "@ ") {
+			local = fmt.Sprintf("user%04d", binary.BigEndian.Uint16(sum[8:10])%10000)
+		}
+		user.Mail = local + "@" + privacyMailDomain
+	}
+	// 与目录中配置了企业微信、钉钉账号的真实用户保持一致
+	user.WeCom = fmt.Sprintf("%x", sum[10:16])
+	user.DingTalk = fmt.Sprintf("%x", sum[16:22])
+	return user
+}
+
+// withPrivacyContacts 隐私模式下用假数据补齐真实用户缺失的联系方式，使所有用户返回的字段一致，仅用于展示
+func withPrivacyContacts(user *LDAPUser, input string) *LDAPUser {
+	if !isPrivacyMode() || user.fake {
+		return user
+	}
+	fake := fakeUser(input)
+	shown := *user
+	if shown.Mobile == "" {
+		shown.Mobile = fake.Mobile
+	}
+	if shown.Mail == "" {
+		shown.Mail = fake.Mail
+	}
+	if shown.WeCom == "" {
+		shown.WeCom = fake.WeCom
+	}
+	if shown.DingTalk == "" {
+		shown.DingTalk = fake.DingTalk
+	}
+	return &shown
+}
+
+// privacyContactUser 隐私模式下缺少所选联系方式的真实用户按假用户处理，与 withPrivacyContacts 展示的联系方式一致
+func privacyContactUser(user *LDAPUser, codeType, input string) *LDAPUser {
+	if !isPrivacyMode() || user.fake {
+		return user
+	}
+	if _, ok := codeIdentifier(codeType, user); ok {
+		return user
+	}
+	return fakeUser(input)
+}
+
+// 由 DN 中的 DC 组件拼出域名，例如 DC=corp,DC=example,DC=com 得到 corp.example.com
+func domainFromDN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return "example.com"
+	}
+	var parts []string
+	for _, rdn := range parsed.RDNs {
+		for _, attr := range rdn.Attributes {
+			if strings.EqualFold(attr.Type, "dc") {
+				parts = append(parts, attr.Value)
+			}
+		}
+	}
+	if len(parts) < 2 {
+		return "example.com"
+	}
+	return strings.Join(parts, ".")
+}
+
+// padResponseTime 隐私模式下将响应耗时补齐到固定下限，配合 defer 使用
+func padResponseTime(start time.Time) {
+	if !isPrivacyMode() {
+		return
+	}
+	if remaining := privacyMinResponseTime - time.Since(start); remaining > 0 {
+		time.Sleep(remaining)
+	}
+}
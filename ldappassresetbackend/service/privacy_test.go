@@ -0,0 +1,150 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+func setupPrivacy(t *testing.T, enabled bool) {
+	t.Helper()
+	setTestConfig(t, fmt.Sprintf(`{
+		"privacy": {"enabled": %v, "secret": "test"},
+		"ldap": {"baseDn": "DC=corp,DC=example,DC=com"}
+	}`, enabled))
+	privacyOnce = sync.Once{}
+	t.Cleanup(func() { privacyOnce = sync.Once{} })
+	loadPrivacyConfig()
+}
+
+func TestFakeUser(t *testing.T) {
+	setupPrivacy(t, true)
+
+	user := fakeUser("Alice")
+	if !user.fake || user.DN != "" {
+		t.Fatalf("fakeUser = %+v, want fake without DN", user)
+	}
+	if !mobilePattern.MatchString(user.Mobile) {
+		t.Errorf("mobile = %q", user.Mobile)
+	}
+	if user.Mail != "alice@corp.example.com" {
+		t.Errorf("mail = %q, want alice@corp.example.com", user.Mail)
+	}
+	if user.WeCom == "" || user.DingTalk == "" {
+		t.Errorf("wecom = %q, dingtalk = %q", user.WeCom, user.DingTalk)
+	}
+	// 同一输入(不区分大小写)始终得到相同结果
+	if again := fakeUser("alice"); again.Mobile != user.Mobile || again.Mail != user.Mail || again.WeCom != user.WeCom {
+		t.Errorf("fakeUser not deterministic: %+v != %+v", again, user)
+	}
+	if other := fakeUser("bob"); other.Mobile == user.Mobile {
+		t.Errorf("different inputs share mobile %q", user.Mobile)
+	}
+
+	// 输入是手机号或邮箱时原样使用，与真实用户的打码结果一致
+	if got := fakeUser("13912345678").Mobile; got != "13912345678" {
+		t.Errorf("mobile input = %q", got)
+	}
+	if got := fakeUser("bob@example.org").Mail; got != "bob@example.org" {
+		t.Errorf("mail input = %q", got)
+	}
+	// 过短的输入生成随机前缀
+	if got := fakeUser("ab").Mail; !strings.HasPrefix(got, "user") || !strings.HasSuffix(got, "@corp.example.com") {
+		t.Errorf("short input mail = %q", got)
+	}
+}
+
+func TestDomainFromDN(t *testing.T) {
+	tests := []struct {
+		dn   string
+		want string
+	}{
+		{"DC=corp,DC=example,DC=com", "corp.example.com"},
+		{"OU=Users,dc=example,dc=org", "example.org"},
+		{"DC=local", "example.com"},
+		{"", "example.com"},
+		{"not a dn", "example.com"},
+	}
+	for _, tt := range tests {
+		if got := domainFromDN(tt.dn); got != tt.want {
+			t.Errorf("domainFromDN(%q) = %q, want %q", tt.dn, got, tt.want)
+		}
+	}
+}
+
+func infoKeys(info g.Map) []string {
+	var keys []string
+	for k := range info {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 隐私模式下缺少联系方式或已锁定的真实用户与不存在的用户返回相同的字段
+func TestUserInfoPrivacy(t *testing.T) {
+	setupPrivacy(t, true)
+	real := &LDAPUser{DN: "CN=Carol,DC=corp,DC=example,DC=com", Username: "carol", Locked: true}
+	fake := fakeUser("nobody")
+
+	realInfo, fakeInfo := userInfo(real, "carol"), userInfo(fake, "nobody")
+	if got, want := infoKeys(realInfo), infoKeys(fakeInfo); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+	for _, info := range []g.Map{realInfo, fakeInfo} {
+		if _, ok := info["locked"]; ok {
+			t.Error("locked returned in privacy mode")
+		}
+		if info["mobile"] == "" || info["mail"] == "" {
+			t.Errorf("empty contact in %v", info)
+		}
+		if info["wecom"] != fakeInfo["wecom"] || info["dingtalk"] != fakeInfo["dingtalk"] {
+			t.Errorf("channels differ: %v vs %v", info, fakeInfo)
+		}
+	}
+	// 补齐只用于展示，不修改用户信息
+	if real.Mobile != "" || real.Mail != "" {
+		t.Errorf("user modified: %+v", real)
+	}
+
+	// 已有的联系方式保持不变
+	withMail := &LDAPUser{DN: "CN=Dave,DC=corp,DC=example,DC=com", Mail: "dave@example.com"}
+	if got := userInfo(withMail, "dave")["mail"]; got != maskMail("dave@example.com") {
+		t.Errorf("mail = %v", got)
+	}
+}
+
+func TestUserInfoWithoutPrivacy(t *testing.T) {
+	setupPrivacy(t, false)
+	user := &LDAPUser{DN: "CN=Carol,DC=corp,DC=example,DC=com", Locked: true}
+	info := userInfo(user, "carol")
+	if info["locked"] != true {
+		t.Errorf("locked = %v, want true", info["locked"])
+	}
+	if info["mobile"] != "" || info["mail"] != "" {
+		t.Errorf("contacts filled without privacy mode: %v", info)
+	}
+}
+
+// 缺少所选联系方式的真实用户按假用户发送和校验，与展示的联系方式一致
+func TestPrivacyContactUser(t *testing.T) {
+	setupPrivacy(t, true)
+	user := &LDAPUser{DN: "CN=Carol,DC=corp,DC=example,DC=com", Mail: "carol@example.com"}
+
+	if got := privacyContactUser(user, "mail", "carol"); got != user {
+		t.Error("user with mail replaced")
+	}
+	got := privacyContactUser(user, "mobile", "carol")
+	if !got.fake || got.Mobile != withPrivacyContacts(user, "carol").Mobile {
+		t.Errorf("mobile user = %+v, want fake with displayed mobile", got)
+	}
+
+	setupPrivacy(t, false)
+	if got := privacyContactUser(user, "mobile", "carol"); got != user {
+		t.Error("user replaced without privacy mode")
+	}
+}
@@ -0,0 +1,549 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/os/gctx"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/text/unicode/norm"
+)
+
+// 安全问题验证方式：用于目录中没有手机号和邮箱的用户，答案规范化后以加盐的 Argon2id 哈希保存
+
+const (
+	questionSaltSize = 16
+	questionKeySize  = 32
+
+	// 下发的问题与验证码有效期相同，期间重复请求返回同一组问题
+	questionChallengeTTL = codeExpiryDuration
+)
+
+var errQuestionsLocked = errors.New("too many failed attempts")
+
+// 可供选择的安全问题，id 用于关联已保存的答案，修改问题文字不影响已设置的答案
+type securityQuestion struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+// 已保存的答案，hash 为 PHC 格式的 Argon2id 哈希
+type questionAnswer struct {
+	ID   string `json:"id"`
+	Hash string `json:"hash"`
+}
+
+var (
+	questionOnce         sync.Once
+	questionsEnabled     bool
+	questionList         []securityQuestion
+	questionEnroll       int // 设置时需要回答的问题个数
+	questionRequired     int // 验证时需要回答的问题个数
+	questionMinLength    int
+	questionMaxFailures  int64
+	questionLockout      time.Duration
+	questionArgonTime    uint32
+	questionArgonMemory  uint32
+	questionArgonThreads uint8
+	questionArgonSem     chan struct{} // 限制同时计算的哈希个数，避免并发请求耗尽内存
+	questionErr          error
+)
+
+func loadQuestionConfig() error {
+	questionOnce.Do(func() {
+		ctx := context.TODO()
+		questionsEnabled = g.Cfg().MustGet(ctx, "securityQuestions.enabled", false).Bool()
+		if !questionsEnabled {
+			return
+		}
+		if err := g.Cfg().MustGet(ctx, "securityQuestions.questions").Scan(&questionList); err != nil {
+			questionErr = fmt.Errorf("invalid securityQuestions.questions: %v", err)
+			return
+		}
+		questionEnroll = g.Cfg().MustGet(ctx, "securityQuestions.enroll", 3).Int()
+		questionRequired = g.Cfg().MustGet(ctx, "securityQuestions.required", 2).Int()
+		questionMinLength = g.Cfg().MustGet(ctx, "securityQuestions.minLength", 2).Int()
+		questionMaxFailures = g.Cfg().MustGet(ctx, "securityQuestions.maxFailures", 5).Int64()
+		questionLockout = time.Duration(g.Cfg().MustGet(ctx, "securityQuestions.lockoutDuration", 30).Int()) * time.Minute
+		questionArgonTime = g.Cfg().MustGet(ctx, "securityQuestions.argon2.time", 2).Uint32()
+		questionArgonMemory = g.Cfg().MustGet(ctx, "securityQuestions.argon2.memory", 19*1024).Uint32()
+		questionArgonThreads = g.Cfg().MustGet(ctx, "securityQuestions.argon2.threads", 1).Uint8()
+		concurrency := g.Cfg().MustGet(ctx, "securityQuestions.argon2.concurrency", 4).Int()
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		questionArgonSem = make(chan struct{}, concurrency)
+
+		seen := make(map[string]bool)
+		for _, q := range questionList {
+			if q.ID == "" || q.Text == "" || seen[q.ID] {
+				questionErr = fmt.Errorf("securityQuestions.questions must have unique, non-empty id and text")
+				return
+			}
+			seen[q.ID] = true
+		}
+		if questionRequired < 1 || questionEnroll < questionRequired || len(questionList) < questionEnroll {
+			questionErr = fmt.Errorf("securityQuestions requires 1 <= required <= enroll <= number of questions")
+		}
+	})
+	return questionErr
+}
+
+// 是否启用安全问题，配置错误时视为未启用
+func isQuestionsEnabled() bool {
+	if err := loadQuestionConfig(); err != nil {
+		g.Log().Error(gctx.New(), "invalid securityQuestions config:", err)
+		return false
+	}
+	return questionsEnabled
+}
+
+func findQuestion(id string) (securityQuestion, bool) {
+	for _, q := range questionList {
+		if q.ID == id {
+			return q, true
+		}
+	}
+	return securityQuestion{}, false
+}
+
+// 规范化答案：统一全半角及大小写，去掉标点和多余空白，避免因输入习惯不同而校验失败
+func normalizeAnswer(answer string) string {
+	answer = strings.ToLower(norm.NFKC.String(answer))
+	answer = strings.Map(func(r rune) rune {
+		if unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			return ' '
+		}
+		return r
+	}, answer)
+	return strings.Join(strings.Fields(answer), " ")
+}
+
+// 计算规范化后答案的哈希，参数随哈希一起保存，调整配置不影响已设置的答案
+func hashAnswer(answer string) (string, error) {
+	salt := make([]byte, questionSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argonKey([]byte(answer), salt, questionArgonTime, questionArgonMemory, questionArgonThreads, questionKeySize)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		questionArgonMemory, questionArgonTime, questionArgonThreads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// 校验答案是否与哈希匹配
+func checkAnswer(answer, encoded string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false
+	}
+	var version int
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+	got := argonKey([]byte(answer), salt, iterations, memory, threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// 计算 Argon2id 哈希，同时进行的计算不超过 argon2.concurrency 个，超出时排队等待
+func argonKey(answer, salt []byte, iterations, memory uint32, threads uint8, keyLen uint32) []byte {
+	if questionArgonSem != nil {
+		questionArgonSem <- struct{}{}
+		defer func() { <-questionArgonSem }()
+	}
+	return argon2.IDKey(answer, salt, iterations, memory, threads, keyLen)
+}
+
+// 读取用户已设置的答案
+func loadAnswers(user *LDAPUser) ([]questionAnswer, error) {
+	if user.fake {
+		return nil, nil
+	}
+	data, ok, err := getQuestionStore().GetAnswers(user.DN)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var answers []questionAnswer
+	if err := json.Unmarshal([]byte(data), &answers); err != nil {
+		return nil, err
+	}
+	return answers, nil
+}
+
+// 用户是否已设置安全问题，隐私模式下对所有用户返回相同结果
+func questionsEnrolled(user *LDAPUser) bool {
+	if !isQuestionsEnabled() {
+		return false
+	}
+	if isPrivacyMode() {
+		return true
+	}
+	answers, err := loadAnswers(user)
+	if err != nil {
+		g.Log().Error(gctx.New(), "failed to load security answers:", err)
+	}
+	return len(answers) >= questionRequired
+}
+
+// 失败次数按用户记录，假用户使用独立的 key，锁定行为与真实用户一致
+func questionKey(user *LDAPUser, username string) string {
+	if user.fake {
+		return "privacy:" + strings.ToLower(username)
+	}
+	return user.DN
+}
+
+// 是否因连续校验失败而被锁定
+func questionsLocked(key string) bool {
+	n, err := getQuestionStore().Failures(key)
+	if err != nil {
+		g.Log().Error(gctx.New(), "failed to load security question failures:", err)
+		return true
+	}
+	return n >= questionMaxFailures
+}
+
+// 抽取需要回答的问题，从已设置的问题中随机选出 required 个并记录下来，校验时只接受这组问题的答案。
+// 有效期内重复请求返回同一组问题，避免反复刷新挑出自己知道答案的问题
+func challengeQuestions(user *LDAPUser, key string) ([]securityQuestion, error) {
+	if ids, ok, err := loadChallenge(key); err != nil {
+		return nil, err
+	} else if ok {
+		return questionsByID(ids), nil
+	}
+
+	ids, err := enrolledQuestionIDs(user)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) < questionRequired {
+		return nil, fmt.Errorf("security questions not enrolled")
+	}
+	mathrand.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+	ids = ids[:questionRequired]
+
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return nil, err
+	}
+	if err := getQuestionStore().SetChallenge(key, string(data), questionChallengeTTL); err != nil {
+		return nil, err
+	}
+	return questionsByID(ids), nil
+}
+
+// 读取下发的问题
+func loadChallenge(key string) ([]string, bool, error) {
+	data, ok, err := getQuestionStore().GetChallenge(key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	var ids []string
+	if err := json.Unmarshal([]byte(data), &ids); err != nil {
+		return nil, false, err
+	}
+	return ids, len(ids) > 0, nil
+}
+
+func questionsByID(ids []string) []securityQuestion {
+	questions := make([]securityQuestion, 0, len(ids))
+	for _, id := range ids {
+		if q, ok := findQuestion(id); ok {
+			questions = append(questions, q)
+		}
+	}
+	return questions
+}
+
+// 用户已设置且仍在配置中的问题
+func enrolledQuestionIDs(user *LDAPUser) ([]string, error) {
+	var ids []string
+	if !user.fake {
+		answers, err := loadAnswers(user)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range answers {
+			if _, ok := findQuestion(a.ID); ok {
+				ids = append(ids, a.ID)
+			}
+		}
+	}
+	// 假用户由输入确定性地生成一组已设置的问题；隐私模式下未设置的真实用户同样处理，
+	// 使响应与已设置的用户无法区分，校验必然失败
+	if user.fake || (isPrivacyMode() && len(ids) < questionRequired) {
+		ids = fakeQuestionIDs(user)
+	}
+	return ids, nil
+}
+
+func fakeQuestionIDs(user *LDAPUser) []string {
+	loadPrivacyConfig()
+	seed := user.Mail
+	if seed == "" {
+		seed = user.DN
+	}
+	weight := func(id string) []byte {
+		mac := hmac.New(sha256.New, privacySecret)
+		mac.Write([]byte("questions:" + seed + ":" + id))
+		return mac.Sum(nil)
+	}
+	ids := make([]string, len(questionList))
+	for i, q := range questionList {
+		ids[i] = q.ID
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return string(weight(ids[i])) < string(weight(ids[j]))
+	})
+	return ids[:questionEnroll]
+}
+
+// verifySecurityAnswers 校验答案，必须且只能回答本次下发的问题，全部正确才算通过
+func verifySecurityAnswers(user *LDAPUser, key string, answers map[string]string) (bool, error) {
+	if !isQuestionsEnabled() {
+		return false, nil
+	}
+	if questionsLocked(key) {
+		return false, errQuestionsLocked
+	}
+
+	// 没有下发过问题时无从校验，不计入失败次数
+	challenge, ok, err := loadChallenge(key)
+	if err != nil || !ok {
+		return false, err
+	}
+	stored, err := loadAnswers(user)
+	if err != nil {
+		return false, err
+	}
+	hashes := make(map[string]string, len(stored))
+	for _, a := range stored {
+		hashes[a.ID] = a.Hash
+	}
+
+	passed := len(answers) == len(challenge)
+	for _, id := range challenge {
+		answer, answered := answers[id]
+		hash, enrolled := hashes[id]
+		if !answered || !enrolled || !checkAnswer(normalizeAnswer(answer), hash) {
+			passed = false
+		}
+	}
+	if passed {
+		if err := getQuestionStore().ResetFailures(key); err != nil {
+			g.Log().Error(gctx.New(), "failed to reset security question failures:", err)
+		}
+		if err := getQuestionStore().DeleteChallenge(key); err != nil {
+			g.Log().Error(gctx.New(), "failed to delete security question challenge:", err)
+		}
+		return true, nil
+	}
+
+	n, err := getQuestionStore().Fail(key, questionLockout)
+	if err != nil {
+		return false, err
+	}
+	if n >= questionMaxFailures {
+		return false, errQuestionsLocked
+	}
+	return false, nil
+}
+
+// SendSecurityQuestions 返回本次需要回答的问题，作为安全问题方式的"发送验证码"
+func SendSecurityQuestions(r *ghttp.Request, user *LDAPUser) {
+	username := r.Get("username").String()
+	if !questionsEnrolled(user) {
+		audit(r, auditCodeSent, auditFailure, user, "questions", "security questions not enrolled")
+		r.Response.WriteJsonExit(g.Map{"code": 10005, "message": "Invalid data"})
+		return
+	}
+	key := questionKey(user, username)
+	checkRateLimit(r, limitByIdentifier, "questions:"+key)
+	if questionsLocked(key) {
+		audit(r, auditCodeSent, auditFailure, user, "questions", errQuestionsLocked.Error())
+		r.Response.WriteJsonExit(g.Map{"code": 10025, "message": errQuestionsLocked.Error()})
+		return
+	}
+
+	questions, err := challengeQuestions(user, key)
+	if err != nil {
+		audit(r, auditCodeSent, auditFailure, user, "questions", err.Error())
+		r.Response.WriteJsonExit(g.Map{"code": 10005, "message": "Invalid data"})
+		return
+	}
+	audit(r, auditCodeSent, auditSuccess, user, "questions", "")
+	r.Response.WriteJsonExit(g.Map{
+		"code":      200,
+		"message":   "Success",
+		"questions": questions,
+	})
+}
+
+// ListSecurityQuestions 返回可供设置的问题列表及需要设置的个数
+func ListSecurityQuestions(r *ghttp.Request) {
+	if !isQuestionsEnabled() {
+		r.Response.WriteJsonExit(g.Map{"code": 10005, "message": "Security questions are not enabled"})
+		return
+	}
+	r.Response.WriteJsonExit(g.Map{
+		"code":      200,
+		"questions": questionList,
+		"enroll":    questionEnroll,
+	})
+}
+
+// 凭当前密码设置安全问题，密码只算一种验证方式，与令牌路径一样需要满足多因素策略
+// 在密码校验通过后再检查策略，避免未经验证即可判断账户是否属于受策略约束的组
+func verifyEnrollPassword(ldapService *LDAPService, user *LDAPUser, password string) error {
+	if err := ldapService.VerifyPassword(user.DN, password); err != nil {
+		return err
+	}
+	return checkFactors(user, &resetClaims{Channel: "password"})
+}
+
+// EnrollSecurityQuestions 设置安全问题，需要其他方式签发的重置令牌或当前密码
+func EnrollSecurityQuestions(r *ghttp.Request) {
+	defer padResponseTime(time.Now())
+
+	if !isQuestionsEnabled() {
+		r.Response.WriteJsonExit(g.Map{"code": 10005, "message": "Security questions are not enabled"})
+		return
+	}
+	username := r.Get("username").String()
+	ldapService, err := GetLDAPService()
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 10007, "message": err.Error()})
+		return
+	}
+	user, err := lookupUser(ldapService, username)
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 10008, "message": err.Error()})
+		return
+	}
+	// 受保护的账户不允许设置安全问题
+	user = checkProtected(r, auditQuestionsEnroll, user)
+
+	var claims *resetClaims
+	if r.Get("token").String() != "" {
+		if claims, err = checkEnrollToken(r, user, "questions"); err != nil {
+			audit(r, auditQuestionsEnroll, auditFailure, user, "questions", err.Error())
+			r.Response.WriteJsonExit(g.Map{"code": enrollTokenErrorCode(err), "message": err.Error()})
+			return
+		}
+	} else {
+		// 没有手机号和邮箱的用户凭当前密码设置，与修改密码一样需要图形验证码
+		if !VerifyCaptcha(r) {
+			r.Response.WriteJsonExit(g.Map{"code": 10006, "message": "Invalid code"})
+			return
+		}
+		if user.fake {
+			audit(r, auditQuestionsEnroll, auditFailure, user, "questions", "")
+			r.Response.WriteJsonExit(g.Map{"code": 10016, "message": ErrInvalidCredentials.Error()})
+			return
+		}
+		password, err := DecryptPassword(r.Get("password").String())
+		if err != nil {
+			r.Response.WriteJsonExit(g.Map{"code": 10002, "message": "Failed to decrypt password"})
+			return
+		}
+		if err := verifyEnrollPassword(ldapService, user, password); err != nil {
+			audit(r, auditQuestionsEnroll, auditFailure, user, "questions", err.Error())
+			if errors.Is(err, ErrInvalidCredentials) {
+				r.Response.WriteJsonExit(g.Map{"code": 10016, "message": err.Error()})
+			}
+			if errors.Is(err, ErrFactorsRequired) {
+				r.Response.WriteJsonExit(g.Map{"code": 10027, "message": err.Error()})
+			}
+			r.Response.WriteJsonExit(g.Map{"code": 10007, "message": err.Error()})
+			return
+		}
+	}
+
+	// 校验提交的答案：问题必须在配置中且不能重复，答案规范化后不能过短
+	input := r.Get("answers").MapStrStr()
+	if len(input) != questionEnroll {
+		r.Response.WriteJsonExit(g.Map{
+			"code":    10026,
+			"message": fmt.Sprintf("exactly %d questions must be answered", questionEnroll),
+		})
+		return
+	}
+	answers := make([]questionAnswer, 0, len(input))
+	for id, answer := range input {
+		if _, ok := findQuestion(id); !ok {
+			r.Response.WriteJsonExit(g.Map{"code": 10026, "message": "unknown question " + id})
+			return
+		}
+		normalized := normalizeAnswer(answer)
+		if utf8.RuneCountInString(normalized) < questionMinLength {
+			r.Response.WriteJsonExit(g.Map{"code": 10026, "message": "answer is too short"})
+			return
+		}
+		hash, err := hashAnswer(normalized)
+		if err != nil {
+			r.Response.WriteJsonExit(g.Map{"code": 10026, "message": "Failed to save answers"})
+			return
+		}
+		answers = append(answers, questionAnswer{ID: id, Hash: hash})
+	}
+
+	// 答案校验通过后消费令牌，令牌只能用于一次绑定
+	if claims != nil {
+		if err := consumeResetToken(claims); err != nil {
+			audit(r, auditQuestionsEnroll, auditFailure, user, "questions", err.Error())
+			r.Response.WriteJsonExit(g.Map{"code": 10018, "message": err.Error()})
+			return
+		}
+	}
+
+	data, err := json.Marshal(answers)
+	if err == nil {
+		err = getQuestionStore().SetAnswers(user.DN, string(data))
+	}
+	if err != nil {
+		audit(r, auditQuestionsEnroll, auditFailure, user, "questions", err.Error())
+		r.Response.WriteJsonExit(g.Map{"code": 10026, "message": "Failed to save answers"})
+		return
+	}
+	// 重新设置后解除之前的锁定，并作废按旧答案下发的问题
+	if err := getQuestionStore().ResetFailures(user.DN); err != nil {
+		g.Log().Error(gctx.New(), "failed to reset security question failures:", err)
+	}
+	if err := getQuestionStore().DeleteChallenge(user.DN); err != nil {
+		g.Log().Error(gctx.New(), "failed to delete security question challenge:", err)
+	}
+	audit(r, auditQuestionsEnroll, auditSuccess, user, "questions", "")
+	r.Response.WriteJsonExit(g.Map{
+		"code":    200,
+		"message": "Success",
+	})
+}
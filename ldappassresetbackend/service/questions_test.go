@@ -0,0 +1,242 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 启用安全问题(四选三，每次回答两个)并使用独立的内存存储
+func setupQuestions(t *testing.T, privacy bool) {
+	t.Helper()
+	setTestConfig(t, fmt.Sprintf(`{
+		"privacy": {"enabled": %v, "secret": "test"},
+		"securityQuestions": {
+			"enabled": true,
+			"questions": [{"id":"q1","text":"Q1"},{"id":"q2","text":"Q2"},{"id":"q3","text":"Q3"},{"id":"q4","text":"Q4"}],
+			"enroll": 3, "required": 2, "maxFailures": 3,
+			"argon2": {"time": 1, "memory": 1024, "threads": 1}
+		}
+	}`, privacy))
+	questionOnce, privacyOnce = sync.Once{}, sync.Once{}
+	t.Cleanup(func() { questionOnce, privacyOnce = sync.Once{}, sync.Once{} })
+	if err := loadQuestionConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	initStores()
+	prev := questionStore
+	questionStore = &memoryQuestionStore{
+		answers:    make(map[string]string),
+		failures:   make(map[string]questionFailures),
+		challenges: make(map[string]questionChallenge),
+	}
+	t.Cleanup(func() { questionStore = prev })
+}
+
+// 为用户设置 q1、q2、q3 的答案，答案为 "answer " + 问题 ID
+func enrollTestAnswers(t *testing.T, user *LDAPUser) {
+	t.Helper()
+	var answers []questionAnswer
+	for _, id := range []string{"q1", "q2", "q3"} {
+		hash, err := hashAnswer(normalizeAnswer("answer " + id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		answers = append(answers, questionAnswer{ID: id, Hash: hash})
+	}
+	data, _ := json.Marshal(answers)
+	if err := getQuestionStore().SetAnswers(user.DN, string(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func challengeIDs(questions []securityQuestion) []string {
+	ids := make([]string, len(questions))
+	for i, q := range questions {
+		ids[i] = q.ID
+	}
+	return ids
+}
+
+// 按问题 ID 给出正确答案
+func correctAnswers(ids ...string) map[string]string {
+	answers := make(map[string]string)
+	for _, id := range ids {
+		answers[id] = "Answer " + id + "!"
+	}
+	return answers
+}
+
+func TestSecurityQuestionChallenge(t *testing.T) {
+	setupQuestions(t, false)
+	user := &LDAPUser{DN: "cn=alice,dc=example,dc=com"}
+	enrollTestAnswers(t, user)
+
+	questions, err := challengeQuestions(user, user.DN)
+	if err != nil {
+		t.Fatalf("challengeQuestions: %v", err)
+	}
+	ids := challengeIDs(questions)
+	if len(ids) != 2 {
+		t.Fatalf("challenge = %v, want 2 questions", ids)
+	}
+	// 有效期内重复请求返回同一组问题
+	for i := 0; i < 5; i++ {
+		again, _ := challengeQuestions(user, user.DN)
+		if fmt.Sprint(challengeIDs(again)) != fmt.Sprint(ids) {
+			t.Fatalf("challenge changed from %v to %v", ids, challengeIDs(again))
+		}
+	}
+
+	ok, err := verifySecurityAnswers(user, user.DN, correctAnswers(ids...))
+	if err != nil || !ok {
+		t.Fatalf("verify issued answers = %v, %v", ok, err)
+	}
+	// 通过后问题作废，需要重新下发
+	if ok, _ := verifySecurityAnswers(user, user.DN, correctAnswers(ids...)); ok {
+		t.Error("challenge reused after success")
+	}
+}
+
+func TestSecurityQuestionAnswersMustMatchChallenge(t *testing.T) {
+	setupQuestions(t, false)
+	user := &LDAPUser{DN: "cn=alice,dc=example,dc=com"}
+	enrollTestAnswers(t, user)
+
+	// 没有下发问题时不能校验，也不计入失败
+	if ok, err := verifySecurityAnswers(user, user.DN, correctAnswers("q1", "q2")); ok || err != nil {
+		t.Fatalf("verify without challenge = %v, %v", ok, err)
+	}
+	if n, _ := getQuestionStore().Failures(user.DN); n != 0 {
+		t.Errorf("failures without challenge = %d", n)
+	}
+
+	questions, err := challengeQuestions(user, user.DN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := challengeIDs(questions)
+	var other string
+	for _, id := range []string{"q1", "q2", "q3"} {
+		if id != ids[0] && id != ids[1] {
+			other = id
+		}
+	}
+
+	tests := []struct {
+		name    string
+		answers map[string]string
+	}{
+		// 用户已设置但未下发的问题，即使答案正确也不接受
+		{"other enrolled question", correctAnswers(ids[0], other)},
+		{"incomplete", correctAnswers(ids[0])},
+		{"extra answer", correctAnswers(ids[0], ids[1], other)},
+		{"wrong answer", map[string]string{ids[0]: "answer " + ids[0], ids[1]: "wrong"}},
+	}
+	for _, tt := range tests {
+		if ok, _ := verifySecurityAnswers(user, user.DN, tt.answers); ok {
+			t.Errorf("%s: verify = true", tt.name)
+		}
+	}
+
+	// 连续失败达到上限后锁定，正确答案也不再接受
+	if ok, err := verifySecurityAnswers(user, user.DN, correctAnswers(ids...)); ok || err != errQuestionsLocked {
+		t.Errorf("verify after lockout = %v, %v; want errQuestionsLocked", ok, err)
+	}
+}
+
+func TestSecurityQuestionsPrivacy(t *testing.T) {
+	setupQuestions(t, true)
+	unenrolled := &LDAPUser{DN: "cn=bob,dc=example,dc=com", Mail: "bob@example.com"}
+	fake := fakeUser("nobody@example.com")
+
+	// 未设置安全问题的真实用户与不存在的用户一样返回问题，无法据此判断账户状态
+	for _, user := range []*LDAPUser{unenrolled, fake} {
+		key := questionKey(user, "nobody@example.com")
+		if !questionsEnrolled(user) {
+			t.Errorf("questionsEnrolled(%q) = false", user.DN)
+		}
+		questions, err := challengeQuestions(user, key)
+		if err != nil || len(questions) != 2 {
+			t.Fatalf("challengeQuestions(%q) = %v, %v", user.DN, questions, err)
+		}
+		if ok, _ := verifySecurityAnswers(user, key, correctAnswers(challengeIDs(questions)...)); ok {
+			t.Errorf("verify for %q = true", user.DN)
+		}
+	}
+}
+
+func TestSecurityQuestionsUnenrolled(t *testing.T) {
+	setupQuestions(t, false)
+	user := &LDAPUser{DN: "cn=bob,dc=example,dc=com"}
+
+	if questionsEnrolled(user) {
+		t.Error("questionsEnrolled = true for user without answers")
+	}
+	if _, err := challengeQuestions(user, user.DN); err == nil {
+		t.Error("challengeQuestions succeeded for user without answers")
+	}
+}
+
+// 凭当前密码设置时同样执行多因素策略，且只在密码正确后检查
+func TestVerifyEnrollPassword(t *testing.T) {
+	setupMFA(t)
+	const adminDN, aliceDN = "CN=Admin,DC=example,DC=com", "CN=Alice,DC=example,DC=com"
+	_, s := newFakeDirectory(t, flavorAD, map[string]*fakeEntry{
+		adminDN: {password: "admin-Pass1"},
+		aliceDN: {password: "alice-Pass1"},
+	})
+	admin := &LDAPUser{DN: adminDN, Groups: []string{"CN=Domain Admins,CN=Users,DC=example,DC=com"}}
+	alice := &LDAPUser{DN: aliceDN}
+
+	tests := []struct {
+		name     string
+		user     *LDAPUser
+		password string
+		want     error
+	}{
+		{"regular user", alice, "alice-Pass1", nil},
+		{"regular user wrong password", alice, "guess", ErrInvalidCredentials},
+		{"admin", admin, "admin-Pass1", ErrFactorsRequired},
+		{"admin wrong password", admin, "guess", ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyEnrollPassword(s, tt.user, tt.password); !errors.Is(err, tt.want) {
+				t.Errorf("verifyEnrollPassword = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// 同时计算的哈希个数受 argon2.concurrency 限制
+func TestArgonConcurrencyLimit(t *testing.T) {
+	setupQuestions(t, false)
+	questionArgonSem = make(chan struct{}, 1)
+
+	questionArgonSem <- struct{}{}
+	done := make(chan string, 1)
+	go func() {
+		hash, _ := hashAnswer("blue")
+		done <- hash
+	}()
+	select {
+	case <-done:
+		t.Fatal("hashAnswer ran while the limit was reached")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-questionArgonSem
+
+	select {
+	case hash := <-done:
+		if !checkAnswer("blue", hash) {
+			t.Error("hash does not verify")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("hashAnswer did not run after a slot was released")
+	}
+}
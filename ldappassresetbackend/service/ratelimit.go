@@ -0,0 +1,332 @@
+package service
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/os/gctx"
+)
+
+// RateLimiter 限流存储接口，与验证码存储使用相同的后端
+type RateLimiter interface {
+	// Take 从令牌桶中取出一个令牌，不允许时返回需要等待的时间
+	Take(key string, rule rateRule) (bool, time.Duration, error)
+	// Daily 获取当日计数
+	Daily(key string) (int64, error)
+	// IncrDaily 累加当日计数并返回累加后的值
+	IncrDaily(key string) (int64, error)
+}
+
+// rateRule 令牌桶规则，每分钟补充 perMinute 个令牌，最多累积 burst 个
+type rateRule struct {
+	perMinute float64
+	burst     int
+}
+
+func (r rateRule) enabled() bool {
+	return r.perMinute > 0 && r.burst > 0
+}
+
+// 每秒补充的令牌数
+func (r rateRule) rate() float64 {
+	return r.perMinute / 60
+}
+
+// 令牌桶从空到满所需的时间，超过该时间未访问的桶可以直接丢弃
+func (r rateRule) fillDuration() time.Duration {
+	return time.Duration(float64(r.burst) / r.rate() * float64(time.Second))
+}
+
+// 限流维度
+const (
+	limitByIP         = "ip"
+	limitByUsername   = "username"
+	limitByIdentifier = "identifier"
+)
+
+var (
+	rateLimitOnce    sync.Once
+	rateLimitEnabled bool
+	rateRules        map[string]rateRule
+	smsDailyLimit    int64 // 单个手机号每日短信上限
+	smsDailyTotal    int64 // 全部手机号每日短信总量上限
+)
+
+func loadRateLimitConfig() {
+	rateLimitOnce.Do(func() {
+		ctx := context.TODO()
+		rateLimitEnabled = g.Cfg().MustGet(ctx, "rateLimit.enabled", true).Bool()
+		rateRules = map[string]rateRule{
+			limitByIP: {
+				perMinute: g.Cfg().MustGet(ctx, "rateLimit.ip.perMinute", 30).Float64(),
+				burst:     g.Cfg().MustGet(ctx, "rateLimit.ip.burst", 20).Int(),
+			},
+			limitByUsername: {
+				perMinute: g.Cfg().MustGet(ctx, "rateLimit.username.perMinute", 10).Float64(),
+				burst:     g.Cfg().MustGet(ctx, "rateLimit.username.burst", 10).Int(),
+			},
+			limitByIdentifier: {
+				perMinute: g.Cfg().MustGet(ctx, "rateLimit.identifier.perMinute", 1).Float64(),
+				burst:     g.Cfg().MustGet(ctx, "rateLimit.identifier.burst", 3).Int(),
+			},
+		}
+		smsDailyLimit = g.Cfg().MustGet(ctx, "rateLimit.smsDailyLimit", 10).Int64()
+		smsDailyTotal = g.Cfg().MustGet(ctx, "rateLimit.smsDailyTotal", 0).Int64()
+	})
+}
+
+// 按指定维度限流，超出时返回 429 并设置 Retry-After
+func checkRateLimit(r *ghttp.Request, dimension, value string) {
+	loadRateLimitConfig()
+	rule := rateRules[dimension]
+	if !rateLimitEnabled || !rule.enabled() || value == "" {
+		return
+	}
+	ok, wait, err := getRateLimiter().Take(dimension+":"+value, rule)
+	if err != nil {
+		// 限流存储异常时放行，避免影响正常使用
+		g.Log().Error(gctx.New(), "rate limit check failed:", err)
+		return
+	}
+	if !ok {
+		writeTooManyRequests(r, wait)
+	}
+}
+
+// 短信每日发送上限检查，只读取计数，超出时返回 429，重试时间为次日零点
+func checkSmsDailyLimit(r *ghttp.Request, mobile string) {
+	loadRateLimitConfig()
+	if !rateLimitEnabled {
+		return
+	}
+	for _, c := range smsDailyCounters(mobile) {
+		if c.limit <= 0 {
+			continue
+		}
+		n, err := getRateLimiter().Daily(c.key)
+		if err != nil {
+			g.Log().Error(gctx.New(), "sms daily limit check failed:", err)
+			continue
+		}
+		if n >= c.limit {
+			now := time.Now()
+			tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+			writeTooManyRequests(r, tomorrow.Sub(now))
+		}
+	}
+}
+
+// 短信发送成功后再计入每日上限，未实际发送的请求不消耗配额
+func countSmsSent(mobile string) {
+	loadRateLimitConfig()
+	if !rateLimitEnabled {
+		return
+	}
+	for _, c := range smsDailyCounters(mobile) {
+		if c.limit <= 0 {
+			continue
+		}
+		if _, err := getRateLimiter().IncrDaily(c.key); err != nil {
+			g.Log().Error(gctx.New(), "sms daily count failed:", err)
+		}
+	}
+}
+
+type smsDailyCounter struct {
+	key   string
+	limit int64
+}
+
+// 单个手机号及全部手机号的当日计数
+func smsDailyCounters(mobile string) []smsDailyCounter {
+	day := time.Now().Format("20060102")
+	return []smsDailyCounter{
+		{"sms:" + day + ":" + mobile, smsDailyLimit},
+		{"sms:" + day, smsDailyTotal},
+	}
+}
+
+func writeTooManyRequests(r *ghttp.Request, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	r.Response.Header().Set("Retry-After", strconv.Itoa(seconds))
+	r.Response.WriteHeader(http.StatusTooManyRequests)
+	r.Response.WriteJsonExit(g.Map{
+		"code":       10019,
+		"message":    "Too many requests",
+		"retryAfter": seconds,
+	})
+}
+
+// RateLimitMiddleware 接口限流中间件，按客户端 IP 及请求中的用户名限流
+func RateLimitMiddleware(r *ghttp.Request) {
+	checkRateLimit(r, limitByIP, clientIP(r))
+	checkRateLimit(r, limitByUsername, r.Get("username").String())
+	r.Middleware.Next()
+}
+
+// ---------------- 内存实现 ----------------
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	idle   time.Duration // 空闲超过该时长即视为已满，可清理
+}
+
+type dailyCounter struct {
+	count   int64
+	expires time.Time
+}
+
+type memoryRateLimiter struct {
+	sync.Mutex
+	buckets   map[string]*tokenBucket
+	counters  map[string]*dailyCounter
+	lastSweep time.Time
+}
+
+func (l *memoryRateLimiter) Take(key string, rule rateRule) (bool, time.Duration, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(rule.burst), last: now, idle: rule.fillDuration()}
+		l.buckets[key] = b
+	}
+	// 按经过的时间补充令牌
+	b.tokens = math.Min(float64(rule.burst), b.tokens+now.Sub(b.last).Seconds()*rule.rate())
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	wait := time.Duration((1 - b.tokens) / rule.rate() * float64(time.Second))
+	return false, wait, nil
+}
+
+func (l *memoryRateLimiter) Daily(key string) (int64, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	c, ok := l.counters[key]
+	if !ok || time.Now().After(c.expires) {
+		return 0, nil
+	}
+	return c.count, nil
+}
+
+func (l *memoryRateLimiter) IncrDaily(key string) (int64, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+	c, ok := l.counters[key]
+	if !ok || now.After(c.expires) {
+		c = &dailyCounter{expires: now.Add(24 * time.Hour)}
+		l.counters[key] = c
+	}
+	c.count++
+	return c.count, nil
+}
+
+// 每分钟清理一次已满的令牌桶和过期计数
+func (l *memoryRateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > b.idle {
+			delete(l.buckets, k)
+		}
+	}
+	for k, c := range l.counters {
+		if now.After(c.expires) {
+			delete(l.counters, k)
+		}
+	}
+}
+
+// ---------------- Redis 实现 ----------------
+
+// 原子地补充并取出令牌
+// KEYS[1] 令牌桶 key，ARGV[1] 每秒补充令牌数，ARGV[2] 容量，ARGV[3] 当前毫秒时间戳
+// 返回 {是否允许, 需要等待的毫秒数}
+const tokenBucketScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local data = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(data[1]) or burst
+local ts = tonumber(data[2]) or now
+tokens = math.min(burst, tokens + (now - ts) / 1000 * rate)
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000))
+return {allowed, wait}
+`
+
+// 原子地累加计数，首次创建时设置过期时间，避免计数永不过期
+// KEYS[1] 计数 key，ARGV[1] 过期时间(秒)
+const incrDailyScript = `
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then
+	redis.call('EXPIRE', KEYS[1], ARGV[1])
+end
+return n
+`
+
+type redisRateLimiter struct {
+	prefix string
+}
+
+func (l *redisRateLimiter) Take(key string, rule rateRule) (bool, time.Duration, error) {
+	v, err := g.Redis().Eval(context.TODO(), tokenBucketScript, 1,
+		[]string{l.prefix + "ratelimit:" + key},
+		[]interface{}{rule.rate(), rule.burst, time.Now().UnixMilli()})
+	if err != nil {
+		return false, 0, err
+	}
+	result := v.Ints()
+	if len(result) != 2 {
+		return false, 0, nil
+	}
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
+
+func (l *redisRateLimiter) Daily(key string) (int64, error) {
+	v, err := g.Redis().Get(context.TODO(), l.prefix+"daily:"+key)
+	if err != nil {
+		return 0, err
+	}
+	return v.Int64(), nil
+}
+
+func (l *redisRateLimiter) IncrDaily(key string) (int64, error) {
+	v, err := g.Redis().Eval(context.TODO(), incrDailyScript, 1,
+		[]string{l.prefix + "daily:" + key}, []interface{}{int64((24 * time.Hour).Seconds())})
+	if err != nil {
+		return 0, err
+	}
+	return v.Int64(), nil
+}
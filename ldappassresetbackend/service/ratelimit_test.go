@@ -0,0 +1,89 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimiterTake(t *testing.T) {
+	l := &memoryRateLimiter{
+		buckets:  make(map[string]*tokenBucket),
+		counters: make(map[string]*dailyCounter),
+	}
+	rule := rateRule{perMinute: 1, burst: 2}
+
+	for i := 0; i < rule.burst; i++ {
+		if ok, _, _ := l.Take("ip:203.0.113.7", rule); !ok {
+			t.Fatalf("Take %d rejected within burst", i)
+		}
+	}
+	ok, wait, _ := l.Take("ip:203.0.113.7", rule)
+	if ok {
+		t.Fatal("Take allowed after burst")
+	}
+	if wait <= 0 || wait > time.Minute {
+		t.Errorf("wait = %v, want (0, 1m]", wait)
+	}
+	// 不同的 key 使用独立的令牌桶
+	if ok, _, _ := l.Take("ip:203.0.113.8", rule); !ok {
+		t.Error("Take for another key rejected")
+	}
+}
+
+func TestMemoryRateLimiterDaily(t *testing.T) {
+	l := &memoryRateLimiter{
+		buckets:  make(map[string]*tokenBucket),
+		counters: make(map[string]*dailyCounter),
+	}
+
+	// 读取计数不会累加
+	for i := 0; i < 3; i++ {
+		if n, _ := l.Daily("sms:13800000000"); n != 0 {
+			t.Fatalf("Daily = %d, want 0", n)
+		}
+	}
+	for i := int64(1); i <= 3; i++ {
+		if n, _ := l.IncrDaily("sms:13800000000"); n != i {
+			t.Errorf("IncrDaily = %d, want %d", n, i)
+		}
+	}
+	if n, _ := l.Daily("sms:13800000000"); n != 3 {
+		t.Errorf("Daily = %d, want 3", n)
+	}
+}
+
+func TestRedisRateLimiterDaily(t *testing.T) {
+	mr := newTestRedis(t)
+	l := &redisRateLimiter{prefix: "test:"}
+
+	if n, err := l.Daily("sms:13800000000"); err != nil || n != 0 {
+		t.Fatalf("Daily = %d, %v; want 0", n, err)
+	}
+	for i := int64(1); i <= 2; i++ {
+		if n, err := l.IncrDaily("sms:13800000000"); err != nil || n != i {
+			t.Errorf("IncrDaily = %d, %v; want %d", n, err, i)
+		}
+	}
+	if n, _ := l.Daily("sms:13800000000"); n != 2 {
+		t.Errorf("Daily = %d, want 2", n)
+	}
+	// 计数与过期时间一起写入
+	if ttl := mr.TTL("test:daily:sms:13800000000"); ttl != 24*time.Hour {
+		t.Errorf("daily ttl = %v, want 24h", ttl)
+	}
+}
+
+func TestRedisRateLimiterTake(t *testing.T) {
+	newTestRedis(t)
+	l := &redisRateLimiter{prefix: "test:"}
+	rule := rateRule{perMinute: 1, burst: 2}
+
+	for i := 0; i < rule.burst; i++ {
+		if ok, _, err := l.Take("ip:203.0.113.7", rule); err != nil || !ok {
+			t.Fatalf("Take %d = %v, %v", i, ok, err)
+		}
+	}
+	if ok, wait, _ := l.Take("ip:203.0.113.7", rule); ok || wait <= 0 {
+		t.Errorf("Take after burst = %v, wait %v", ok, wait)
+	}
+}
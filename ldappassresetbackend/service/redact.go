@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/gogf/gf/v2/os/glog"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+// 日志脱敏：在输出前清除验证码、密码、密文以及完整的手机号和邮箱
+
+const redactedText = "[REDACTED]"
+
+var (
+	// 键值形式的敏感字段，例如 newPassword=xxx、"token":"xxx"、"answers":{...}
+	secretFieldPattern = regexp.MustCompile(`(?i)("?\b(?:password|newPassword|oldPassword|verifyCode|token|secret|answers?)"?\s*[:=]\s*"?)(\{[^}]*\}|[^"\s,&}]+)`)
+	// 较长的 Base64/Base64URL 串，通常是 RSA 密文或重置令牌
+	cipherPattern = regexp.MustCompile(`[A-Za-z0-9+/_\-]{64,}={0,2}(?:\.[A-Za-z0-9_\-]+)?`)
+	// 邮箱
+	redactMailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)+`)
+	// 手机号，包括带国际区号的格式
+	redactMobilePattern = regexp.MustCompile(`\+?\d{0,3}1[3-9]\d{9}\b|\+\d{8,15}\b`)
+	// 6 位数字验证码
+	otpPattern = regexp.MustCompile(`\b\d{6}\b`)
+)
+
+// redact 对日志内容脱敏
+func redact(s string) string {
+	s = secretFieldPattern.ReplaceAllString(s, "${1}"+redactedText)
+	s = cipherPattern.ReplaceAllString(s, redactedText)
+	s = redactMailPattern.ReplaceAllStringFunc(s, redactMail)
+	s = redactMobilePattern.ReplaceAllStringFunc(s, maskMobile)
+	s = otpPattern.ReplaceAllString(s, "******")
+	return s
+}
+
+// 邮箱打码，前缀或域名过短无法部分打码时整体替换，避免原样输出
+func redactMail(mail string) string {
+	at := strings.Index(mail, "@")
+	if at < 3 || strings.Index(mail[at+1:], ".") < 2 {
+		return redactedText
+	}
+	return maskMail(mail)
+}
+
+// RedactLogHandler glog 处理器，对所有日志内容脱敏后再交给后续处理器输出
+func RedactLogHandler(ctx context.Context, in *glog.HandlerInput) {
+	in.Content = redact(in.Content)
+	for i, v := range in.Values {
+		in.Values[i] = redact(gconv.String(v))
+	}
+	in.Next(ctx)
+}
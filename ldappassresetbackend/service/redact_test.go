@@ -0,0 +1,65 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/gogf/gf/v2/os/glog"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"mobile", "send code to 13812345678", "send code to 138****8"},
+		{"mobile with country code", "mobile +8613812345678 ok", "mobile +86****8 ok"},
+		{"international mobile", "to +14155550123", "to +14****3"},
+		{"mail", "mail alice@example.com", "mail ali**@ex*****.com"},
+		{"short mail", "mail ab@example.com", "mail " + redactedText},
+		{"short domain", "mail alice@x.com", "mail " + redactedText},
+		{"code", "code 123456 sent", "code ****** sent"},
+		{"code field", "verifyCode=654321&username=bob", "verifyCode=" + redactedText + "&username=bob"},
+		{"token json", `{"token":"abc.def"}`, `{"token":"` + redactedText + `"}`},
+		{"token value", "issued eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9eyJzdWIiOiIxMjM0NTY3ODkwIiwibmFtZSI6IkpvaG4.sig", "issued " + redactedText},
+		{"password", "newPassword=hunter2", "newPassword=" + redactedText},
+		{"answers", `{"answers":{"q1":"Beijing","q2":"Rex"}}`, `{"answers":` + redactedText + `}`},
+		{"other numbers", "order 1234567 id", "order 1234567 id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redact(tt.in); got != tt.want {
+				t.Errorf("redact(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactLogHandler(t *testing.T) {
+	newLogger := func(buf *bytes.Buffer) *glog.Logger {
+		l := glog.New()
+		l.SetWriter(buf)
+		l.SetStdoutPrint(false)
+		return l
+	}
+
+	var redacted, raw bytes.Buffer
+	logger := newLogger(&redacted)
+	logger.SetHandlers(RedactLogHandler)
+	logger.Info(context.Background(), "code", "123456", "sent to", "alice@example.com")
+
+	out := redacted.String()
+	if strings.Contains(out, "123456") || strings.Contains(out, "alice@example.com") {
+		t.Errorf("handler did not redact: %q", out)
+	}
+
+	// 未设置处理器的日志(如审计日志)保持原样
+	other := newLogger(&raw)
+	other.Print(context.Background(), `{"target":"alice@example.com"}`)
+	if !strings.Contains(raw.String(), "alice@example.com") {
+		t.Errorf("logger without handler was redacted: %q", raw.String())
+	}
+}
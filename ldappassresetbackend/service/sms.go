@@ -1,94 +1,212 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
 
-	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
-	dysmsapi20170525 "github.com/alibabacloud-go/dysmsapi-20170525/v4/client"
-	util "github.com/alibabacloud-go/tea-utils/v2/service"
-	"github.com/alibabacloud-go/tea/tea"
 	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gctx"
 )
 
-type SmsService struct {
-	accessKeyID     string
-	accessKeySecret string
-	signName        string
-	templateCode    string
+// SmsProvider 短信网关接口，由 sms.provider 选择具体实现
+type SmsProvider interface {
+	// Send 按消息类型对应的模板发送短信，params 为模板变量
+	Send(phone, message string, params map[string]string) error
 }
 
-func NewSmsService() *SmsService {
-	cfg := g.Cfg().MustGet(context.TODO(), "sms").Map()
+// 短信消息类型，每种类型在各网关中对应一个模板
+const (
+	smsMessageCode   = "code"   // 验证码，变量 code
+	smsMessageNotify = "notify" // 密码已修改通知，变量 time、ip
+)
+
+// 各消息类型的模板变量顺序，供只支持位置参数的网关使用
+var smsTemplateParams = map[string][]string{
+	smsMessageCode:   {"code"},
+	smsMessageNotify: {"time", "ip"},
+}
+
+// 消息类型未配置模板
+var errSmsTemplateNotConfigured = errors.New("sms template not configured")
+
+// 短信发送失败的类别，决定是否重试及接口返回的状态码
+type smsErrorKind int
+
+const (
+	smsErrFailed        smsErrorKind = iota // 其他错误
+	smsErrTransient                         // 网络或网关临时故障，可以重试
+	smsErrRateLimited                       // 触发网关对号码的发送频率限制
+	smsErrInvalidNumber                     // 手机号无效或无法接收短信
+	smsErrConfig                            // 密钥、签名或模板配置错误
+)
+
+// SmsError 短信网关返回的错误
+type SmsError struct {
+	Provider string // 网关名称
+	Code     string // 网关错误码
+	Message  string
+	Kind     smsErrorKind
+}
 
-	accessKeyID, _ := cfg["accessKeyID"].(string)
-	accessKeySecret, _ := cfg["accessKeySecret"].(string)
-	signName, _ := cfg["signName"].(string)
-	templateCode, _ := cfg["templateCode"].(string)
+func (e *SmsError) Error() string {
+	return fmt.Sprintf("%s sms error %s: %s", e.Provider, e.Code, e.Message)
+}
+
+// 判断错误类别，网络错误视为临时故障
+func smsErrorKindOf(err error) smsErrorKind {
+	var smsErr *SmsError
+	if errors.As(err, &smsErr) {
+		return smsErr.Kind
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return smsErrTransient
+	}
+	return smsErrFailed
+}
 
-	return &SmsService{
-		accessKeyID:     accessKeyID,
-		accessKeySecret: accessKeySecret,
-		signName:        signName,
-		templateCode:    templateCode,
+// 网关未返回可识别的错误码时按 HTTP 状态码判断类别
+func smsErrorKindFromStatus(status int) smsErrorKind {
+	switch {
+	case status == http.StatusTooManyRequests || status >= http.StatusInternalServerError:
+		return smsErrTransient
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return smsErrConfig
 	}
+	return smsErrFailed
 }
 
-var SignName string
-var TemplateCode string
+// 将短信发送错误映射为接口状态码
+func smsErrorResponseCode(err error) int {
+	switch smsErrorKindOf(err) {
+	case smsErrRateLimited:
+		return 10020
+	case smsErrInvalidNumber:
+		return 10021
+	}
+	return 10011
+}
+
+var (
+	smsProvider     SmsProvider
+	smsProviderErr  error
+	smsProviderOnce sync.Once
+	smsHTTPClient   *http.Client
+)
+
+// 根据 sms.provider 初始化短信网关，可选 aliyun(默认)、tencent、twilio、webhook
+func getSmsProvider() (SmsProvider, error) {
+	smsProviderOnce.Do(func() {
+		ctx := context.TODO()
+		smsHTTPClient = &http.Client{
+			Timeout: time.Duration(g.Cfg().MustGet(ctx, "sms.timeout", 10).Int()) * time.Second,
+		}
+		provider := g.Cfg().MustGet(ctx, "sms.provider", "aliyun").String()
+		switch provider {
+		case "aliyun":
+			smsProvider, smsProviderErr = newAliyunSmsProvider()
+		case "tencent":
+			smsProvider = newTencentSmsProvider()
+		case "twilio":
+			smsProvider, smsProviderErr = newTwilioSmsProvider()
+		case "webhook":
+			smsProvider, smsProviderErr = newWebhookSmsProvider()
+		default:
+			smsProviderErr = fmt.Errorf("unknown sms provider %q", provider)
+		}
+	})
+	return smsProvider, smsProviderErr
+}
 
 func SendSms(phone, code string) error {
-	client, _err := NewSmsService().CreateClient()
-	if _err != nil {
-		return _err
+	provider, err := getSmsProvider()
+	if err != nil {
+		return err
 	}
+	return sendSmsWithRetry(provider, phone, smsMessageCode, map[string]string{"code": code})
+}
 
-	sendSmsRequest := &dysmsapi20170525.SendSmsRequest{
-		PhoneNumbers:  tea.String(phone),
-		SignName:      tea.String(SignName),
-		TemplateCode:  tea.String(TemplateCode),
-		TemplateParam: tea.String("{\"code\":\"" + code + "\"}"),
+// SendPasswordChangedSms 发送密码已修改通知短信，当前网关未配置通知模板时不发送
+func SendPasswordChangedSms(phone string, notice PasswordChangedNotice) error {
+	provider, err := getSmsProvider()
+	if err != nil {
+		return err
 	}
-	runtime := &util.RuntimeOptions{}
-
-	tryErr := func() (_e error) {
-		defer func() {
-			if r := tea.Recover(recover()); r != nil {
-				_e = r
-			}
-		}()
-		_, _err := client.SendSmsWithOptions(sendSmsRequest, runtime)
-		// fmt.Println(response)
-		if _err != nil {
-			return _err
+	err = sendSmsWithRetry(provider, phone, smsMessageNotify, map[string]string{
+		"time": notice.Time,
+		"ip":   notice.IP,
+	})
+	if errors.Is(err, errSmsTemplateNotConfigured) {
+		return nil
+	}
+	return err
+}
+
+// 发送短信，网关临时故障时按指数退避重试
+func sendSmsWithRetry(provider SmsProvider, phone, message string, params map[string]string) error {
+	ctx := context.TODO()
+	attempts := g.Cfg().MustGet(ctx, "sms.retry.attempts", 3).Int()
+	backoff := time.Duration(g.Cfg().MustGet(ctx, "sms.retry.initialBackoff", 500).Int()) * time.Millisecond
+	maxBackoff := time.Duration(g.Cfg().MustGet(ctx, "sms.retry.maxBackoff", 4000).Int()) * time.Millisecond
+
+	var err error
+	for i := 1; ; i++ {
+		err = provider.Send(phone, message, params)
+		if err == nil || i >= attempts || smsErrorKindOf(err) != smsErrTransient {
+			return err
+		}
+		// 加入随机抖动，避免多个请求同时重试
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		g.Log().Warningf(gctx.New(), "sms send attempt %d failed, retrying in %s: %v", i, wait, err)
+		time.Sleep(wait)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
 		}
+	}
+}
 
-		return nil
-	}()
-
-	if tryErr != nil {
-		var error = &tea.SDKError{}
-		if _t, ok := tryErr.(*tea.SDKError); ok {
-			error = _t
-		} else {
-			error.Message = tea.String(tryErr.Error())
+// 读取文本短信模板，模板中以 {{.code}}、{{.time}} 等引用变量
+func loadSmsTextTemplates(key string) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template)
+	for message, text := range g.Cfg().MustGet(context.TODO(), key).MapStrStr() {
+		tmpl, err := template.New(message).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sms template %s.%s: %v", key, message, err)
 		}
-		// 错误 message
-		fmt.Println(tea.StringValue(error.Message))
+		templates[message] = tmpl
 	}
-	return _err
+	return templates, nil
 }
 
-func (s *SmsService) CreateClient() (_result *dysmsapi20170525.Client, _err error) {
-	config := &openapi.Config{
-		AccessKeyId:     tea.String(s.accessKeyID),
-		AccessKeySecret: tea.String(s.accessKeySecret),
+// 渲染文本短信模板
+func renderSmsText(templates map[string]*template.Template, message string, params map[string]string) (string, error) {
+	tmpl, ok := templates[message]
+	if !ok {
+		return "", errSmsTemplateNotConfigured
 	}
-	SignName = s.signName
-	TemplateCode = s.templateCode
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, params); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
 
-	config.Endpoint = tea.String("dysmsapi.aliyuncs.com")
-	_result = &dysmsapi20170525.Client{}
-	_result, _err = dysmsapi20170525.NewClient(config)
-	return _result, _err
+// 转为 E.164 格式，未带国家码的 11 位手机号视为中国大陆号码
+func toE164(phone string) string {
+	if strings.HasPrefix(phone, "+") {
+		return phone
+	}
+	if mobilePattern.MatchString(phone) {
+		return "+86" + phone
+	}
+	return "+" + phone
 }
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
+	dysmsapi20170525 "github.com/alibabacloud-go/dysmsapi-20170525/v4/client"
+	util "github.com/alibabacloud-go/tea-utils/v2/service"
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// 阿里云短信，配置沿用 sms 下的原有字段
+type aliyunSmsProvider struct {
+	client    *dysmsapi20170525.Client
+	signName  string
+	templates map[string]string // 消息类型到 TemplateCode
+}
+
+func newAliyunSmsProvider() (*aliyunSmsProvider, error) {
+	ctx := context.TODO()
+	cfg := g.Cfg().MustGet(ctx, "sms").Map()
+
+	accessKeyID, _ := cfg["accessKeyID"].(string)
+	accessKeySecret, _ := cfg["accessKeySecret"].(string)
+	signName, _ := cfg["signName"].(string)
+	templateCode, _ := cfg["templateCode"].(string)
+
+	config := &openapi.Config{
+		AccessKeyId:     tea.String(accessKeyID),
+		AccessKeySecret: tea.String(accessKeySecret),
+	}
+	// 可指向测试桩，带 http:// 前缀时以明文 HTTP 访问
+	endpoint := g.Cfg().MustGet(ctx, "sms.endpoint", "dysmsapi.aliyuncs.com").String()
+	if protocol, host, ok := strings.Cut(endpoint, "://"); ok {
+		config.Protocol = tea.String(protocol)
+		endpoint = host
+	}
+	config.Endpoint = tea.String(endpoint)
+	client, err := dysmsapi20170525.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := map[string]string{smsMessageCode: templateCode}
+	if notifyTemplateCode := g.Cfg().MustGet(ctx, "notify.smsTemplateCode").String(); notifyTemplateCode != "" {
+		templates[smsMessageNotify] = notifyTemplateCode
+	}
+	return &aliyunSmsProvider{
+		client:    client,
+		signName:  signName,
+		templates: templates,
+	}, nil
+}
+
+func (p *aliyunSmsProvider) Send(phone, message string, params map[string]string) error {
+	templateCode, ok := p.templates[message]
+	if !ok {
+		return errSmsTemplateNotConfigured
+	}
+	templateParam, _err := json.Marshal(params)
+	if _err != nil {
+		return _err
+	}
+	sendSmsRequest := &dysmsapi20170525.SendSmsRequest{
+		PhoneNumbers:  tea.String(phone),
+		SignName:      tea.String(p.signName),
+		TemplateCode:  tea.String(templateCode),
+		TemplateParam: tea.String(string(templateParam)),
+	}
+	runtime := &util.RuntimeOptions{}
+
+	var response *dysmsapi20170525.SendSmsResponse
+	tryErr := func() (_e error) {
+		defer func() {
+			if r := tea.Recover(recover()); r != nil {
+				_e = r
+			}
+		}()
+		response, _e = p.client.SendSmsWithOptions(sendSmsRequest, runtime)
+		return _e
+	}()
+	if tryErr != nil {
+		return aliyunSDKError(tryErr)
+	}
+
+	// 请求成功不代表发送成功，需要检查业务返回码
+	if response == nil || response.Body == nil {
+		return &SmsError{Provider: "aliyun", Message: "empty response", Kind: smsErrTransient}
+	}
+	if code := tea.StringValue(response.Body.Code); code != "OK" {
+		return &SmsError{
+			Provider: "aliyun",
+			Code:     code,
+			Message:  tea.StringValue(response.Body.Message),
+			Kind:     aliyunErrorKind(code),
+		}
+	}
+	return nil
+}
+
+// SDK 错误转为 SmsError，网络错误原样返回
+func aliyunSDKError(err error) error {
+	sdkErr, ok := err.(*tea.SDKError)
+	if !ok {
+		return err
+	}
+	code := tea.StringValue(sdkErr.Code)
+	kind := aliyunErrorKind(code)
+	if kind == smsErrFailed && sdkErr.StatusCode != nil {
+		kind = smsErrorKindFromStatus(tea.IntValue(sdkErr.StatusCode))
+	}
+	return &SmsError{
+		Provider: "aliyun",
+		Code:     code,
+		Message:  tea.StringValue(sdkErr.Message),
+		Kind:     kind,
+	}
+}
+
+// 阿里云短信错误码分类
+func aliyunErrorKind(code string) smsErrorKind {
+	switch {
+	case code == "isv.BUSINESS_LIMIT_CONTROL", code == "isv.DAY_LIMIT_CONTROL":
+		return smsErrRateLimited
+	case code == "isv.MOBILE_NUMBER_ILLEGAL", code == "isv.MOBILE_COUNT_OVER_LIMIT",
+		code == "isv.DOMESTIC_NUMBER_NOT_SUPPORTED":
+		return smsErrInvalidNumber
+	case code == "isp.SYSTEM_ERROR", strings.HasPrefix(code, "Throttling"), code == "ServiceUnavailable":
+		return smsErrTransient
+	case code == "isv.SMS_SIGNATURE_ILLEGAL", code == "isv.SMS_TEMPLATE_ILLEGAL",
+		code == "isv.TEMPLATE_MISSING_PARAMETERS", code == "isv.INVALID_PARAMETERS",
+		code == "isv.AMOUNT_NOT_ENOUGH", code == "isv.OUT_OF_SERVICE", code == "isv.PRODUCT_UN_SUBSCRIPT",
+		code == "isv.ACCOUNT_NOT_EXISTS", code == "isv.ACCOUNT_ABNORMAL", code == "isp.RAM_PERMISSION_DENY",
+		strings.HasPrefix(code, "InvalidAccessKeyId"), strings.HasPrefix(code, "SignatureDoesNotMatch"):
+		return smsErrConfig
+	}
+	return smsErrFailed
+}
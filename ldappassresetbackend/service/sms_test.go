@@ -0,0 +1,475 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// 启动模拟网关，短信发送使用该服务的 HTTP 客户端
+func newSmsTestServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	prev := smsHTTPClient
+	smsHTTPClient = srv.Client()
+	t.Cleanup(func() { smsHTTPClient = prev })
+	return srv
+}
+
+func newTestTencentProvider(endpoint string) *tencentSmsProvider {
+	return &tencentSmsProvider{
+		endpoint:  endpoint,
+		secretID:  "AKIDtest",
+		secretKey: "secret-key",
+		region:    "ap-guangzhou",
+		sdkAppID:  "1400000000",
+		signName:  "Example",
+		templates: map[string]string{smsMessageCode: "100001"},
+	}
+}
+
+// 按腾讯云文档独立计算签名，用于校验请求中的 Authorization
+func verifyTC3Signature(t *testing.T, r *http.Request, body []byte, secretID, secretKey string) {
+	t.Helper()
+	auth := r.Header.Get("Authorization")
+	timestamp := r.Header.Get("X-TC-Timestamp")
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		t.Fatalf("invalid X-TC-Timestamp %q", timestamp)
+	}
+	date := time.Unix(ts, 0).UTC().Format("2006-01-02")
+	scope := date + "/sms/tc3_request"
+
+	bodyHash := sha256.Sum256(body)
+	canonical := strings.Join([]string{
+		r.Method,
+		"/",
+		"",
+		"content-type:" + r.Header.Get("Content-Type") + "\nhost:" + r.Host + "\n",
+		"content-type;host",
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	stringToSign := "TC3-HMAC-SHA256\n" + timestamp + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("TC3"+secretKey), date)
+	key = hmacSHA256(key, "sms")
+	key = hmacSHA256(key, "tc3_request")
+	want := "TC3-HMAC-SHA256 Credential=" + secretID + "/" + scope +
+		", SignedHeaders=content-type;host, Signature=" + hex.EncodeToString(hmacSHA256(key, stringToSign))
+	if auth != want {
+		t.Errorf("Authorization = %q\nwant %q", auth, want)
+	}
+}
+
+func TestTencentSmsSend(t *testing.T) {
+	srv := newSmsTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get("X-TC-Action"); got != "SendSms" {
+			t.Errorf("X-TC-Action = %q", got)
+		}
+		if got := r.Header.Get("X-TC-Version"); got != tencentSmsVersion {
+			t.Errorf("X-TC-Version = %q", got)
+		}
+		if got := r.Header.Get("X-TC-Region"); got != "ap-guangzhou" {
+			t.Errorf("X-TC-Region = %q", got)
+		}
+		verifyTC3Signature(t, r, body, "AKIDtest", "secret-key")
+
+		var req struct {
+			PhoneNumberSet   []string
+			SmsSdkAppId      string
+			SignName         string
+			TemplateId       string
+			TemplateParamSet []string
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Fatalf("invalid body: %v", err)
+		}
+		if len(req.PhoneNumberSet) != 1 || req.PhoneNumberSet[0] != "+8613800000000" {
+			t.Errorf("PhoneNumberSet = %v", req.PhoneNumberSet)
+		}
+		if req.TemplateId != "100001" || len(req.TemplateParamSet) != 1 || req.TemplateParamSet[0] != "123456" {
+			t.Errorf("template = %s %v", req.TemplateId, req.TemplateParamSet)
+		}
+		io.WriteString(w, `{"Response":{"SendStatusSet":[{"Code":"Ok","Message":"send success"}],"RequestId":"r1"}}`)
+	})
+
+	p := newTestTencentProvider(srv.URL)
+	if err := p.Send("13800000000", smsMessageCode, map[string]string{"code": "123456"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	// 未配置模板的消息类型不发送
+	if err := p.Send("13800000000", smsMessageNotify, nil); !errors.Is(err, errSmsTemplateNotConfigured) {
+		t.Errorf("Send notify = %v, want errSmsTemplateNotConfigured", err)
+	}
+}
+
+func TestTencentSmsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		wantCode string
+		wantKind smsErrorKind
+	}{
+		{"phone rate limited", 200, `{"Response":{"SendStatusSet":[{"Code":"LimitExceeded.PhoneNumberThirtySecondLimit","Message":"limit"}]}}`,
+			"LimitExceeded.PhoneNumberThirtySecondLimit", smsErrRateLimited},
+		{"invalid number", 200, `{"Response":{"SendStatusSet":[{"Code":"InvalidParameterValue.IncorrectPhoneNumber","Message":"bad"}]}}`,
+			"InvalidParameterValue.IncorrectPhoneNumber", smsErrInvalidNumber},
+		{"auth failure", 200, `{"Response":{"Error":{"Code":"AuthFailure.SignatureFailure","Message":"sig"}}}`,
+			"AuthFailure.SignatureFailure", smsErrConfig},
+		{"internal error", 200, `{"Response":{"Error":{"Code":"InternalError.Timeout","Message":"timeout"}}}`,
+			"InternalError.Timeout", smsErrTransient},
+		{"unknown error", 200, `{"Response":{"Error":{"Code":"FailedOperation.Other","Message":"other"}}}`,
+			"FailedOperation.Other", smsErrFailed},
+		{"http 502", 502, `bad gateway`, "502", smsErrTransient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newSmsTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.response)
+			})
+			err := newTestTencentProvider(srv.URL).Send("13800000000", smsMessageCode, map[string]string{"code": "123456"})
+			var smsErr *SmsError
+			if !errors.As(err, &smsErr) {
+				t.Fatalf("Send = %v, want *SmsError", err)
+			}
+			if smsErr.Code != tt.wantCode || smsErr.Kind != tt.wantKind {
+				t.Errorf("SmsError = %s kind %d, want %s kind %d", smsErr.Code, smsErr.Kind, tt.wantCode, tt.wantKind)
+			}
+		})
+	}
+}
+
+func newTestTwilioProvider(t *testing.T, endpoint, messagingServiceSID string) *twilioSmsProvider {
+	t.Helper()
+	return &twilioSmsProvider{
+		endpoint:            endpoint,
+		accountSID:          "AC123",
+		authToken:           "token",
+		from:                "+15005550006",
+		messagingServiceSID: messagingServiceSID,
+		templates: map[string]*template.Template{
+			smsMessageCode: template.Must(template.New(smsMessageCode).Parse("Your code is {{.code}}")),
+		},
+	}
+}
+
+func TestTwilioSmsSend(t *testing.T) {
+	tests := []struct {
+		name                string
+		messagingServiceSID string
+		wantForm            url.Values
+	}{
+		{"from number", "", url.Values{
+			"To": {"+8613800000000"}, "Body": {"Your code is 123456"}, "From": {"+15005550006"},
+		}},
+		{"messaging service", "MG123", url.Values{
+			"To": {"+8613800000000"}, "Body": {"Your code is 123456"}, "MessagingServiceSid": {"MG123"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newSmsTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/2010-04-01/Accounts/AC123/Messages.json" {
+					t.Errorf("path = %s", r.URL.Path)
+				}
+				if user, pass, ok := r.BasicAuth(); !ok || user != "AC123" || pass != "token" {
+					t.Errorf("basic auth = %q %q %v", user, pass, ok)
+				}
+				if err := r.ParseForm(); err != nil {
+					t.Fatal(err)
+				}
+				if r.PostForm.Encode() != tt.wantForm.Encode() {
+					t.Errorf("form = %s, want %s", r.PostForm.Encode(), tt.wantForm.Encode())
+				}
+				w.WriteHeader(http.StatusCreated)
+				io.WriteString(w, `{"sid":"SM123","status":"queued"}`)
+			})
+			p := newTestTwilioProvider(t, srv.URL, tt.messagingServiceSID)
+			if err := p.Send("13800000000", smsMessageCode, map[string]string{"code": "123456"}); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+		})
+	}
+}
+
+func TestTwilioSmsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		wantCode string
+		wantKind smsErrorKind
+	}{
+		{"invalid number", 400, `{"code":21211,"message":"Invalid 'To' Phone Number","status":400}`, "21211", smsErrInvalidNumber},
+		{"rate limited", 400, `{"code":14107,"message":"Message rate limit exceeded","status":400}`, "14107", smsErrRateLimited},
+		{"auth failure", 401, `{"code":20003,"message":"Authenticate","status":401}`, "20003", smsErrConfig},
+		{"too many requests", 429, `{"code":20429,"message":"Too Many Requests","status":429}`, "20429", smsErrTransient},
+		{"unknown code uses status", 503, `{"code":99999,"message":"unavailable","status":503}`, "99999", smsErrTransient},
+		{"non json", 500, `oops`, "500", smsErrTransient},
+		{"bad request", 400, `{"code":21602,"message":"Message body is required","status":400}`, "21602", smsErrFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newSmsTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.response)
+			})
+			err := newTestTwilioProvider(t, srv.URL, "").Send("13800000000", smsMessageCode, map[string]string{"code": "123456"})
+			var smsErr *SmsError
+			if !errors.As(err, &smsErr) {
+				t.Fatalf("Send = %v, want *SmsError", err)
+			}
+			if smsErr.Code != tt.wantCode || smsErr.Kind != tt.wantKind {
+				t.Errorf("SmsError = %s kind %d, want %s kind %d", smsErr.Code, smsErr.Kind, tt.wantCode, tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestWebhookSmsSend(t *testing.T) {
+	var got struct {
+		To   string `json:"to"`
+		Text string `json:"text"`
+		Type string `json:"type"`
+	}
+	srv := newSmsTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("method = %s", r.Method)
+		}
+		if r.Header.Get("X-Api-Key") != "key" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("headers = %v", r.Header)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("invalid body: %v", err)
+		}
+	})
+
+	p := &webhookSmsProvider{
+		url:         srv.URL,
+		method:      http.MethodPut,
+		contentType: "application/json",
+		headers:     map[string]string{"X-Api-Key": "key"},
+		body: template.Must(template.New("body").Funcs(webhookTemplateFuncs).
+			Parse(`{"to":{{json .E164}},"text":{{json .Text}},"type":{{json .Message}}}`)),
+		templates: map[string]*template.Template{
+			smsMessageCode: template.Must(template.New(smsMessageCode).Parse(`code "{{.code}}"`)),
+		},
+	}
+	if err := p.Send("13800000000", smsMessageCode, map[string]string{"code": "123456"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	// 正文中的引号经 json 函数转义后请求体仍是合法 JSON
+	if got.To != "+8613800000000" || got.Text != `code "123456"` || got.Type != smsMessageCode {
+		t.Errorf("body = %+v", got)
+	}
+}
+
+func TestWebhookSmsErrors(t *testing.T) {
+	tests := []struct {
+		status   int
+		wantKind smsErrorKind
+	}{
+		{http.StatusServiceUnavailable, smsErrTransient},
+		{http.StatusTooManyRequests, smsErrTransient},
+		{http.StatusForbidden, smsErrConfig},
+		{http.StatusBadRequest, smsErrFailed},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.status), func(t *testing.T) {
+			srv := newSmsTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			})
+			p := &webhookSmsProvider{
+				url:    srv.URL,
+				method: http.MethodPost,
+				body:   template.Must(template.New("body").Parse(`{}`)),
+			}
+			err := p.Send("13800000000", smsMessageCode, nil)
+			if kind := smsErrorKindOf(err); kind != tt.wantKind {
+				t.Errorf("kind = %d, want %d (%v)", kind, tt.wantKind, err)
+			}
+		})
+	}
+}
+
+// 按顺序返回预设错误的网关
+type fakeSmsProvider struct {
+	errs  []error
+	calls int
+}
+
+func (p *fakeSmsProvider) Send(phone, message string, params map[string]string) error {
+	p.calls++
+	if p.calls <= len(p.errs) {
+		return p.errs[p.calls-1]
+	}
+	return nil
+}
+
+func TestSendSmsWithRetry(t *testing.T) {
+	setTestConfig(t, `{"sms":{"retry":{"attempts":3,"initialBackoff":10,"maxBackoff":20}}}`)
+
+	transient := &SmsError{Provider: "test", Code: "500", Kind: smsErrTransient}
+	limited := &SmsError{Provider: "test", Code: "limit", Kind: smsErrRateLimited}
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{"success", nil, 1, nil},
+		{"recovers after transient errors", []error{transient, transient}, 3, nil},
+		{"gives up after attempts", []error{transient, transient, transient, transient}, 3, transient},
+		{"rate limit is not retried", []error{limited}, 1, limited},
+		{"network error is retried", []error{&url.Error{Op: "Post", URL: "http://sms", Err: timeoutError{}}}, 2, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &fakeSmsProvider{errs: tt.errs}
+			start := time.Now()
+			err := sendSmsWithRetry(p, "13800000000", smsMessageCode, map[string]string{"code": "123456"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if p.calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", p.calls, tt.wantCalls)
+			}
+			// 退避时间不超过配置的上限
+			if elapsed := time.Since(start); elapsed > time.Duration(tt.wantCalls)*20*time.Millisecond+time.Second {
+				t.Errorf("retry took %s", elapsed)
+			}
+		})
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestSmsErrorResponseCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{&SmsError{Kind: smsErrRateLimited}, 10020},
+		{&SmsError{Kind: smsErrInvalidNumber}, 10021},
+		{&SmsError{Kind: smsErrTransient}, 10011},
+		{&SmsError{Kind: smsErrConfig}, 10011},
+		{errors.New("other"), 10011},
+	}
+	for _, tt := range tests {
+		if got := smsErrorResponseCode(tt.err); got != tt.want {
+			t.Errorf("smsErrorResponseCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+// 启动模拟的阿里云短信接口，并通过 sms.endpoint 指向该服务
+func newTestAliyunProvider(t *testing.T, handler http.HandlerFunc) *aliyunSmsProvider {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	setTestConfig(t, `{"sms": {"accessKeyID": "LTAItest", "accessKeySecret": "secret", "signName": "Example",
+		"templateCode": "SMS_100001", "endpoint": "`+srv.URL+`"}}`)
+	p, err := newAliyunSmsProvider()
+	if err != nil {
+		t.Fatalf("newAliyunSmsProvider: %v", err)
+	}
+	return p
+}
+
+func TestAliyunSmsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		wantCode string
+		wantKind smsErrorKind
+	}{
+		{"ok", 200, `{"Code":"OK","Message":"OK","BizId":"1","RequestId":"r"}`, "", 0},
+		{"business limit", 200, `{"Code":"isv.BUSINESS_LIMIT_CONTROL","Message":"触发分钟级流控","RequestId":"r"}`,
+			"isv.BUSINESS_LIMIT_CONTROL", smsErrRateLimited},
+		{"illegal number", 200, `{"Code":"isv.MOBILE_NUMBER_ILLEGAL","Message":"非法手机号","RequestId":"r"}`,
+			"isv.MOBILE_NUMBER_ILLEGAL", smsErrInvalidNumber},
+		{"signature illegal", 200, `{"Code":"isv.SMS_SIGNATURE_ILLEGAL","Message":"签名不合法","RequestId":"r"}`,
+			"isv.SMS_SIGNATURE_ILLEGAL", smsErrConfig},
+		{"sdk access key", 404, `{"Code":"InvalidAccessKeyId.NotFound","Message":"Specified access key is not found.","RequestId":"r"}`,
+			"InvalidAccessKeyId.NotFound", smsErrConfig},
+		{"sdk 503", 503, `{"Code":"ServiceUnavailable","Message":"The request has failed due to a temporary failure of the server.","RequestId":"r"}`,
+			"ServiceUnavailable", smsErrTransient},
+		{"sdk 500 unknown code", 500, `{"Code":"UnknownError","Message":"internal","RequestId":"r"}`,
+			"UnknownError", smsErrTransient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestAliyunProvider(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.response)
+			})
+			err := p.Send("13800000000", smsMessageCode, map[string]string{"code": "123456"})
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatalf("Send = %v", err)
+				}
+				return
+			}
+			var smsErr *SmsError
+			if !errors.As(err, &smsErr) {
+				t.Fatalf("Send = %v, want *SmsError", err)
+			}
+			if smsErr.Code != tt.wantCode || smsErr.Kind != tt.wantKind {
+				t.Errorf("SmsError = %s kind %d, want %s kind %d", smsErr.Code, smsErr.Kind, tt.wantCode, tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestAliyunSmsSend(t *testing.T) {
+	var (
+		got    url.Values
+		header http.Header
+	)
+	p := newTestAliyunProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		got, header = r.Form, r.Header
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"Code":"OK","Message":"OK","BizId":"1","RequestId":"r"}`)
+	})
+
+	if err := p.Send("13800000000", smsMessageCode, map[string]string{"code": "123456"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if header.Get("x-acs-action") != "SendSms" || got.Get("PhoneNumbers") != "13800000000" ||
+		got.Get("SignName") != "Example" || got.Get("TemplateCode") != "SMS_100001" {
+		t.Errorf("request = %v", got)
+	}
+	if got.Get("TemplateParam") != `{"code":"123456"}` {
+		t.Errorf("TemplateParam = %q", got.Get("TemplateParam"))
+	}
+	if auth := header.Get("Authorization"); !strings.Contains(auth, "Credential=LTAItest") || !strings.Contains(auth, "Signature=") {
+		t.Errorf("Authorization = %q", auth)
+	}
+
+	// 未配置通知模板时不发送
+	if err := p.Send("13800000000", smsMessageNotify, nil); err != errSmsTemplateNotConfigured {
+		t.Errorf("Send notify = %v, want errSmsTemplateNotConfigured", err)
+	}
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// Twilio 短信，正文由 sms.twilio.templates 中的文本模板渲染
+type twilioSmsProvider struct {
+	endpoint            string
+	accountSID          string
+	authToken           string
+	from                string
+	messagingServiceSID string
+	templates           map[string]*template.Template
+}
+
+func newTwilioSmsProvider() (*twilioSmsProvider, error) {
+	ctx := context.TODO()
+	templates, err := loadSmsTextTemplates("sms.twilio.templates")
+	if err != nil {
+		return nil, err
+	}
+	return &twilioSmsProvider{
+		endpoint:            g.Cfg().MustGet(ctx, "sms.twilio.endpoint", "https://api.twilio.com").String(),
+		accountSID:          g.Cfg().MustGet(ctx, "sms.twilio.accountSid").String(),
+		authToken:           g.Cfg().MustGet(ctx, "sms.twilio.authToken").String(),
+		from:                g.Cfg().MustGet(ctx, "sms.twilio.from").String(),
+		messagingServiceSID: g.Cfg().MustGet(ctx, "sms.twilio.messagingServiceSid").String(),
+		templates:           templates,
+	}, nil
+}
+
+type twilioErrorResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (p *twilioSmsProvider) Send(phone, message string, params map[string]string) error {
+	text, err := renderSmsText(p.templates, message, params)
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	form.Set("To", toE164(phone))
+	form.Set("Body", text)
+	// 配置了 Messaging Service 时优先使用，由 Twilio 选择发送号码
+	if p.messagingServiceSID != "" {
+		form.Set("MessagingServiceSid", p.messagingServiceSID)
+	} else {
+		form.Set("From", p.from)
+	}
+
+	endpoint := strings.TrimRight(p.endpoint, "/") + "/2010-04-01/Accounts/" + url.PathEscape(p.accountSID) + "/Messages.json"
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(p.accountSID, p.authToken)
+
+	resp, err := smsHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		smsErr := &SmsError{
+			Provider: "twilio",
+			Code:     strconv.Itoa(resp.StatusCode),
+			Message:  string(body),
+			Kind:     smsErrorKindFromStatus(resp.StatusCode),
+		}
+		var e twilioErrorResponse
+		if json.Unmarshal(body, &e) == nil && e.Code != 0 {
+			smsErr.Code = strconv.Itoa(e.Code)
+			smsErr.Message = e.Message
+			if kind := twilioErrorKind(e.Code); kind != smsErrFailed {
+				smsErr.Kind = kind
+			}
+		}
+		return smsErr
+	}
+	return nil
+}
+
+// Twilio 错误码分类，未列出的按 HTTP 状态码判断
+func twilioErrorKind(code int) smsErrorKind {
+	switch code {
+	case 14107, 30022: // 同一号码发送过于频繁
+		return smsErrRateLimited
+	case 21211, 21214, 21408, 21610, 21612, 21614: // 号码无效、不可达、已退订或地区未开通
+		return smsErrInvalidNumber
+	case 20429: // API 并发超限
+		return smsErrTransient
+	case 20003, 20404, 21606, 21659: // 认证失败、账号不存在或发送号码不可用
+		return smsErrConfig
+	}
+	return smsErrFailed
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// 通用 HTTP 短信网关，请求体由 sms.webhook.body 模板渲染
+type webhookSmsProvider struct {
+	url         string
+	method      string
+	contentType string
+	headers     map[string]string
+	body        *template.Template
+	templates   map[string]*template.Template
+}
+
+// 请求体模板变量
+type webhookSmsData struct {
+	Phone   string            // 原始手机号
+	E164    string            // E.164 格式手机号
+	Message string            // 消息类型 code 或 notify
+	Text    string            // 由 sms.webhook.templates 渲染的短信正文，未配置时为空
+	Params  map[string]string // 模板变量
+}
+
+// 请求体模板可用的函数，json 将值编码为 JSON 字符串，避免特殊字符破坏请求体
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func newWebhookSmsProvider() (*webhookSmsProvider, error) {
+	ctx := context.TODO()
+	url := g.Cfg().MustGet(ctx, "sms.webhook.url").String()
+	if url == "" {
+		return nil, fmt.Errorf("sms.webhook.url is required")
+	}
+	body, err := template.New("body").Funcs(webhookTemplateFuncs).Option("missingkey=zero").
+		Parse(g.Cfg().MustGet(ctx, "sms.webhook.body").String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid sms.webhook.body: %v", err)
+	}
+	templates, err := loadSmsTextTemplates("sms.webhook.templates")
+	if err != nil {
+		return nil, err
+	}
+	return &webhookSmsProvider{
+		url:         url,
+		method:      strings.ToUpper(g.Cfg().MustGet(ctx, "sms.webhook.method", http.MethodPost).String()),
+		contentType: g.Cfg().MustGet(ctx, "sms.webhook.contentType", "application/json").String(),
+		headers:     g.Cfg().MustGet(ctx, "sms.webhook.headers").MapStrStr(),
+		body:        body,
+		templates:   templates,
+	}, nil
+}
+
+func (p *webhookSmsProvider) Send(phone, message string, params map[string]string) error {
+	data := webhookSmsData{
+		Phone:   phone,
+		E164:    toE164(phone),
+		Message: message,
+		Params:  params,
+	}
+	// 配置了文本模板时渲染正文，否则由网关侧按消息类型选择模板
+	if len(p.templates) > 0 {
+		text, err := renderSmsText(p.templates, message, params)
+		if err != nil {
+			return err
+		}
+		data.Text = text
+	}
+	var payload bytes.Buffer
+	if err := p.body.Execute(&payload, data); err != nil {
+		return fmt.Errorf("sms webhook body template failed: %v", err)
+	}
+
+	req, err := http.NewRequest(p.method, p.url, &payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", p.contentType)
+	for k, v := range p.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := smsHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return &SmsError{
+			Provider: "webhook",
+			Code:     strconv.Itoa(resp.StatusCode),
+			Message:  string(body),
+			Kind:     smsErrorKindFromStatus(resp.StatusCode),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,748 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/database/gredis"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/os/gctx"
+)
+
+// CodeStore 验证码存储接口，默认使用内存，多副本部署时可切换为 Redis
+type CodeStore interface {
+	// Store 存储验证码并记录发送时间
+	Store(identifier, code string) error
+	// Verify 校验验证码，通过时在同一步中删除以保证只能使用一次，失败时累加尝试次数
+	Verify(identifier, code string) (bool, error)
+	// Delete 删除验证码
+	Delete(identifier string) error
+	// AllowedToSend 是否已过最小发送间隔
+	AllowedToSend(identifier string) (bool, error)
+	// MarkSent 只记录发送时间，不保存验证码
+	MarkSent(identifier string) error
+}
+
+// CaptchaStore 图形验证码存储接口
+type CaptchaStore interface {
+	// Set 存储图形验证码答案
+	Set(id, answer string) error
+	// Get 获取图形验证码答案，过期或不存在时返回 false
+	Get(id string) (string, bool, error)
+	// Delete 删除图形验证码
+	Delete(id string) error
+}
+
+// TokenStore 重置令牌存储接口，用于保证令牌只能使用一次
+type TokenStore interface {
+	// Save 记录已签发的令牌
+	Save(id string, ttl time.Duration) error
+	// Consume 消费令牌，令牌不存在或已被使用时返回 false
+	Consume(id string) (bool, error)
+}
+
+// TOTPStore TOTP 密钥及已使用时间步存储接口，密钥以密文保存
+type TOTPStore interface {
+	// GetSecret 获取用户的密钥，未绑定时返回 false
+	GetSecret(user string) (string, bool, error)
+	// SetSecret 保存用户的密钥
+	SetSecret(user, secret string) error
+	// MarkUsed 记录已使用的时间步，该时间步已使用过时返回 false
+	MarkUsed(user string, step int64, ttl time.Duration) (bool, error)
+}
+
+// QuestionStore 安全问题答案及校验失败次数存储接口，答案以哈希保存
+type QuestionStore interface {
+	// GetAnswers 获取用户的答案记录，未设置时返回 false
+	GetAnswers(user string) (string, bool, error)
+	// SetAnswers 保存用户的答案记录，覆盖之前的设置
+	SetAnswers(user, answers string) error
+	// Failures 获取连续校验失败次数
+	Failures(user string) (int64, error)
+	// Fail 累加失败次数并返回累加后的值，记录在最后一次失败 ttl 后过期
+	Fail(user string, ttl time.Duration) (int64, error)
+	// ResetFailures 清除失败次数
+	ResetFailures(user string) error
+	// GetChallenge 获取本次下发的问题，过期或不存在时返回 false
+	GetChallenge(user string) (string, bool, error)
+	// SetChallenge 保存本次下发的问题
+	SetChallenge(user, ids string, ttl time.Duration) error
+	// DeleteChallenge 删除下发的问题
+	DeleteChallenge(user string) error
+}
+
+var (
+	codeStore     CodeStore
+	captchaStore  CaptchaStore
+	tokenStore    TokenStore
+	totpStore     TOTPStore
+	questionStore QuestionStore
+	rateLimiter   RateLimiter
+	storeOnce     sync.Once
+)
+
+// 根据配置初始化存储，store.type 可选 memory(默认) 或 redis
+func initStores() {
+	storeOnce.Do(func() {
+		storeType := g.Cfg().MustGet(context.TODO(), "store.type", "memory").String()
+		switch storeType {
+		case "redis":
+			prefix := g.Cfg().MustGet(context.TODO(), "store.prefix", "ldapreset:").String()
+			codeStore = &redisCodeStore{prefix: prefix}
+			captchaStore = &redisCaptchaStore{prefix: prefix}
+			tokenStore = &redisTokenStore{prefix: prefix}
+			totpStore = &redisTOTPStore{prefix: prefix}
+			questionStore = &redisQuestionStore{prefix: prefix}
+			rateLimiter = &redisRateLimiter{prefix: prefix}
+		default:
+			if storeType != "memory" {
+				g.Log().Warningf(gctx.New(), "unknown store type %q, fallback to memory", storeType)
+			}
+			memCodeStore := &memoryCodeStore{store: make(map[string]codeData)}
+			memCaptchaStore := &memoryCaptchaStore{store: make(map[string]captchaData)}
+			startMemoryJanitor(memCodeStore, memCaptchaStore, time.Minute)
+			codeStore = memCodeStore
+			captchaStore = memCaptchaStore
+			tokenStore = &memoryTokenStore{store: make(map[string]time.Time)}
+			totpStore = &memoryTOTPStore{
+				secrets: make(map[string]string),
+				used:    make(map[string]time.Time),
+			}
+			questionStore = &memoryQuestionStore{
+				answers:    make(map[string]string),
+				failures:   make(map[string]questionFailures),
+				challenges: make(map[string]questionChallenge),
+			}
+			rateLimiter = &memoryRateLimiter{
+				buckets:  make(map[string]*tokenBucket),
+				counters: make(map[string]*dailyCounter),
+			}
+		}
+	})
+}
+
+func getCodeStore() CodeStore {
+	initStores()
+	return codeStore
+}
+
+func getCaptchaStore() CaptchaStore {
+	initStores()
+	return captchaStore
+}
+
+func getTokenStore() TokenStore {
+	initStores()
+	return tokenStore
+}
+
+func getTOTPStore() TOTPStore {
+	initStores()
+	return totpStore
+}
+
+func getQuestionStore() QuestionStore {
+	initStores()
+	return questionStore
+}
+
+func getRateLimiter() RateLimiter {
+	initStores()
+	return rateLimiter
+}
+
+// ---------------- 内存实现 ----------------
+
+type codeData struct {
+	code     string
+	created  time.Time
+	tryCount int       // 跟踪尝试次数
+	lastSend time.Time // 记录上次发送时间
+}
+
+type memoryCodeStore struct {
+	sync.Mutex // 确保并发安全
+	store      map[string]codeData
+}
+
+func (s *memoryCodeStore) Store(identifier, code string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.store[identifier] = codeData{
+		code:     code,
+		created:  time.Now(),
+		tryCount: 0,          // 初始化尝试次数为 0
+		lastSend: time.Now(), // 记录发送时间
+	}
+	return nil
+}
+
+func (s *memoryCodeStore) Delete(identifier string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.store, identifier)
+	return nil
+}
+
+func (s *memoryCodeStore) Verify(identifier, code string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	storedCodeData, ok := s.store[identifier]
+	// 只记录了发送时间的条目保留到发送间隔结束
+	if !ok || storedCodeData.code == "" {
+		return false, nil
+	}
+
+	// 检查验证码是否过期
+	if time.Since(storedCodeData.created) > codeExpiryDuration {
+		// 超过超时时间，删除验证码
+		delete(s.store, identifier)
+		return false, nil
+	}
+
+	// 检查尝试次数是否超出限制
+	if storedCodeData.tryCount >= maxTryCount {
+		// 超出最大尝试次数，删除验证码
+		delete(s.store, identifier)
+		return false, nil
+	}
+
+	// 验证验证码是否匹配，匹配后立即删除，并发请求中只有一个能通过
+	if storedCodeData.code == code {
+		delete(s.store, identifier)
+		return true, nil
+	}
+
+	// 验证失败，增加尝试次数
+	storedCodeData.tryCount++
+	s.store[identifier] = storedCodeData
+	return false, nil
+}
+
+func (s *memoryCodeStore) AllowedToSend(identifier string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if storedCodeData, ok := s.store[identifier]; ok {
+		if time.Since(storedCodeData.lastSend) < minSendInterval {
+			return false, nil // 如果时间间隔小于最小发送间隔，返回 false
+		}
+	}
+	return true, nil
+}
+
+func (s *memoryCodeStore) MarkSent(identifier string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.store[identifier] = codeData{lastSend: time.Now()}
+	return nil
+}
+
+// 清理已过期且已过发送间隔的验证码
+func (s *memoryCodeStore) sweep(now time.Time) {
+	s.Lock()
+	defer s.Unlock()
+
+	for k, data := range s.store {
+		if now.Sub(data.created) > codeExpiryDuration && now.Sub(data.lastSend) >= minSendInterval {
+			delete(s.store, k)
+		}
+	}
+}
+
+type captchaData struct {
+	answer  string
+	created time.Time
+}
+
+type memoryCaptchaStore struct {
+	sync.RWMutex
+	store map[string]captchaData
+}
+
+func (s *memoryCaptchaStore) Set(id, answer string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.store[id] = captchaData{
+		answer:  answer,
+		created: time.Now(),
+	}
+	return nil
+}
+
+func (s *memoryCaptchaStore) Get(id string) (string, bool, error) {
+	s.RLock()
+	data, exists := s.store[id]
+	s.RUnlock()
+
+	if !exists {
+		return "", false, nil
+	}
+	// 验证码超时
+	if time.Since(data.created) > captchaExpiryDuration {
+		return "", false, nil
+	}
+	return data.answer, true, nil
+}
+
+func (s *memoryCaptchaStore) Delete(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.store, id)
+	return nil
+}
+
+// 清理已过期的图形验证码
+func (s *memoryCaptchaStore) sweep(now time.Time) {
+	s.Lock()
+	defer s.Unlock()
+
+	for k, data := range s.store {
+		if now.Sub(data.created) > captchaExpiryDuration {
+			delete(s.store, k)
+		}
+	}
+}
+
+// 定期清理内存中的验证码和图形验证码，未被校验的记录不会在读写时被删除，需要后台清理
+func startMemoryJanitor(code *memoryCodeStore, captcha *memoryCaptchaStore, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			code.sweep(now)
+			captcha.sweep(now)
+		}
+	}()
+}
+
+type memoryTokenStore struct {
+	sync.Mutex
+	store map[string]time.Time // 令牌过期时间
+}
+
+func (s *memoryTokenStore) Save(id string, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+
+	// 顺带清理过期令牌
+	now := time.Now()
+	for k, expires := range s.store {
+		if now.After(expires) {
+			delete(s.store, k)
+		}
+	}
+	s.store[id] = now.Add(ttl)
+	return nil
+}
+
+func (s *memoryTokenStore) Consume(id string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	expires, ok := s.store[id]
+	if !ok {
+		return false, nil
+	}
+	delete(s.store, id)
+	return time.Now().Before(expires), nil
+}
+
+// 内存中的密钥在重启后丢失，仅适用于测试，生产环境应使用 Redis 或 LDAP 属性
+type memoryTOTPStore struct {
+	sync.Mutex
+	secrets map[string]string
+	used    map[string]time.Time // 已使用的时间步及其过期时间
+}
+
+func (s *memoryTOTPStore) GetSecret(user string) (string, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	secret, ok := s.secrets[user]
+	return secret, ok, nil
+}
+
+func (s *memoryTOTPStore) SetSecret(user, secret string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.secrets[user] = secret
+	return nil
+}
+
+func (s *memoryTOTPStore) MarkUsed(user string, step int64, ttl time.Duration) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	// 顺带清理过期记录
+	now := time.Now()
+	for k, expires := range s.used {
+		if now.After(expires) {
+			delete(s.used, k)
+		}
+	}
+	key := user + ":" + strconv.FormatInt(step, 10)
+	if _, ok := s.used[key]; ok {
+		return false, nil
+	}
+	s.used[key] = now.Add(ttl)
+	return true, nil
+}
+
+type questionFailures struct {
+	count   int64
+	expires time.Time
+}
+
+type questionChallenge struct {
+	ids     string
+	expires time.Time
+}
+
+// 与 TOTP 相同，内存中的答案在重启后丢失，生产环境应使用 Redis
+type memoryQuestionStore struct {
+	sync.Mutex
+	answers    map[string]string
+	failures   map[string]questionFailures
+	challenges map[string]questionChallenge
+}
+
+func (s *memoryQuestionStore) GetAnswers(user string) (string, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	answers, ok := s.answers[user]
+	return answers, ok, nil
+}
+
+func (s *memoryQuestionStore) SetAnswers(user, answers string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.answers[user] = answers
+	return nil
+}
+
+func (s *memoryQuestionStore) Failures(user string) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	f, ok := s.failures[user]
+	if !ok || time.Now().After(f.expires) {
+		return 0, nil
+	}
+	return f.count, nil
+}
+
+func (s *memoryQuestionStore) Fail(user string, ttl time.Duration) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	// 顺带清理过期记录
+	now := time.Now()
+	for k, f := range s.failures {
+		if now.After(f.expires) {
+			delete(s.failures, k)
+		}
+	}
+	f := s.failures[user]
+	f.count++
+	f.expires = now.Add(ttl)
+	s.failures[user] = f
+	return f.count, nil
+}
+
+func (s *memoryQuestionStore) ResetFailures(user string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.failures, user)
+	return nil
+}
+
+func (s *memoryQuestionStore) GetChallenge(user string) (string, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	c, ok := s.challenges[user]
+	if !ok || time.Now().After(c.expires) {
+		return "", false, nil
+	}
+	return c.ids, true, nil
+}
+
+func (s *memoryQuestionStore) SetChallenge(user, ids string, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+
+	// 顺带清理过期记录
+	now := time.Now()
+	for k, c := range s.challenges {
+		if now.After(c.expires) {
+			delete(s.challenges, k)
+		}
+	}
+	s.challenges[user] = questionChallenge{ids: ids, expires: now.Add(ttl)}
+	return nil
+}
+
+func (s *memoryQuestionStore) DeleteChallenge(user string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.challenges, user)
+	return nil
+}
+
+// ---------------- Redis 实现 ----------------
+
+// 原子地校验验证码并累加尝试次数，通过时删除验证码
+// KEYS[1] 验证码 key，ARGV[1] 用户提交的验证码，ARGV[2] 最大尝试次数
+const verifyCodeScript = `
+local stored = redis.call('HGET', KEYS[1], 'code')
+if not stored then
+	return 0
+end
+local tries = tonumber(redis.call('HGET', KEYS[1], 'tryCount') or '0')
+if tries >= tonumber(ARGV[2]) then
+	redis.call('DEL', KEYS[1])
+	return 0
+end
+if stored == ARGV[1] then
+	redis.call('DEL', KEYS[1])
+	return 1
+end
+redis.call('HINCRBY', KEYS[1], 'tryCount', 1)
+return 0
+`
+
+// 原子地覆盖验证码并记录发送时间，避免中途失败留下没有 TTL 或只写了一半的 key
+// KEYS[1] 验证码 key，KEYS[2] 发送时间 key，ARGV[1] 验证码，ARGV[2] 验证码有效期(秒)，
+// ARGV[3] 最小发送间隔(秒)，ARGV[4] 当前时间戳
+const storeCodeScript = `
+redis.call('DEL', KEYS[1])
+redis.call('HSET', KEYS[1], 'code', ARGV[1], 'tryCount', 0)
+redis.call('EXPIRE', KEYS[1], ARGV[2])
+redis.call('SET', KEYS[2], ARGV[4], 'EX', ARGV[3])
+return 1
+`
+
+type redisCodeStore struct {
+	prefix string
+}
+
+func (s *redisCodeStore) codeKey(identifier string) string {
+	return s.prefix + "code:" + identifier
+}
+
+func (s *redisCodeStore) sendKey(identifier string) string {
+	return s.prefix + "send:" + identifier
+}
+
+func (s *redisCodeStore) Store(identifier, code string) error {
+	_, err := g.Redis().Eval(context.TODO(), storeCodeScript, 2,
+		[]string{s.codeKey(identifier), s.sendKey(identifier)},
+		[]interface{}{code, int64(codeExpiryDuration.Seconds()), int64(minSendInterval.Seconds()), time.Now().Unix()})
+	return err
+}
+
+func (s *redisCodeStore) Verify(identifier, code string) (bool, error) {
+	v, err := g.Redis().Eval(context.TODO(), verifyCodeScript, 1,
+		[]string{s.codeKey(identifier)}, []interface{}{code, maxTryCount})
+	if err != nil {
+		return false, err
+	}
+	return v.Int() == 1, nil
+}
+
+func (s *redisCodeStore) Delete(identifier string) error {
+	_, err := g.Redis().Del(context.TODO(), s.codeKey(identifier))
+	return err
+}
+
+func (s *redisCodeStore) AllowedToSend(identifier string) (bool, error) {
+	n, err := g.Redis().Exists(context.TODO(), s.sendKey(identifier))
+	if err != nil {
+		return false, err
+	}
+	return n == 0, nil
+}
+
+func (s *redisCodeStore) MarkSent(identifier string) error {
+	return g.Redis().SetEX(context.TODO(), s.sendKey(identifier), time.Now().Unix(), int64(minSendInterval.Seconds()))
+}
+
+type redisCaptchaStore struct {
+	prefix string
+}
+
+func (s *redisCaptchaStore) key(id string) string {
+	return s.prefix + "captcha:" + id
+}
+
+func (s *redisCaptchaStore) Set(id, answer string) error {
+	return g.Redis().SetEX(context.TODO(), s.key(id), answer, int64(captchaExpiryDuration.Seconds()))
+}
+
+func (s *redisCaptchaStore) Get(id string) (string, bool, error) {
+	v, err := g.Redis().Get(context.TODO(), s.key(id))
+	if err != nil {
+		return "", false, err
+	}
+	if v.IsNil() {
+		return "", false, nil
+	}
+	return v.String(), true, nil
+}
+
+func (s *redisCaptchaStore) Delete(id string) error {
+	_, err := g.Redis().Del(context.TODO(), s.key(id))
+	return err
+}
+
+type redisTokenStore struct {
+	prefix string
+}
+
+func (s *redisTokenStore) key(id string) string {
+	return s.prefix + "token:" + id
+}
+
+func (s *redisTokenStore) Save(id string, ttl time.Duration) error {
+	return g.Redis().SetEX(context.TODO(), s.key(id), 1, int64(ttl.Seconds()))
+}
+
+func (s *redisTokenStore) Consume(id string) (bool, error) {
+	// DEL 是原子操作，只有一个请求能删除成功
+	n, err := g.Redis().Del(context.TODO(), s.key(id))
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+type redisTOTPStore struct {
+	prefix string
+}
+
+func (s *redisTOTPStore) secretKey(user string) string {
+	return s.prefix + "totp:secret:" + user
+}
+
+func (s *redisTOTPStore) GetSecret(user string) (string, bool, error) {
+	v, err := g.Redis().Get(context.TODO(), s.secretKey(user))
+	if err != nil {
+		return "", false, err
+	}
+	if v.IsNil() {
+		return "", false, nil
+	}
+	return v.String(), true, nil
+}
+
+func (s *redisTOTPStore) SetSecret(user, secret string) error {
+	_, err := g.Redis().Set(context.TODO(), s.secretKey(user), secret)
+	return err
+}
+
+func (s *redisTOTPStore) MarkUsed(user string, step int64, ttl time.Duration) (bool, error) {
+	// SET NX 保证同一时间步只有一个请求能成功
+	seconds := int64(ttl.Seconds())
+	v, err := g.Redis().Set(context.TODO(), s.prefix+"totp:used:"+user+":"+strconv.FormatInt(step, 10), 1,
+		gredis.SetOption{TTLOption: gredis.TTLOption{EX: &seconds}, NX: true})
+	if err != nil {
+		return false, err
+	}
+	return !v.IsNil(), nil
+}
+
+// 原子地累加失败次数并顺延过期时间
+// KEYS[1] 失败次数 key，ARGV[1] 过期时间(秒)
+const incrFailuresScript = `
+local n = redis.call('INCR', KEYS[1])
+redis.call('EXPIRE', KEYS[1], ARGV[1])
+return n
+`
+
+type redisQuestionStore struct {
+	prefix string
+}
+
+func (s *redisQuestionStore) answersKey(user string) string {
+	return s.prefix + "questions:answers:" + user
+}
+
+func (s *redisQuestionStore) failuresKey(user string) string {
+	return s.prefix + "questions:failures:" + user
+}
+
+func (s *redisQuestionStore) challengeKey(user string) string {
+	return s.prefix + "questions:challenge:" + user
+}
+
+func (s *redisQuestionStore) GetAnswers(user string) (string, bool, error) {
+	v, err := g.Redis().Get(context.TODO(), s.answersKey(user))
+	if err != nil {
+		return "", false, err
+	}
+	if v.IsNil() {
+		return "", false, nil
+	}
+	return v.String(), true, nil
+}
+
+func (s *redisQuestionStore) SetAnswers(user, answers string) error {
+	_, err := g.Redis().Set(context.TODO(), s.answersKey(user), answers)
+	return err
+}
+
+func (s *redisQuestionStore) Failures(user string) (int64, error) {
+	v, err := g.Redis().Get(context.TODO(), s.failuresKey(user))
+	if err != nil {
+		return 0, err
+	}
+	return v.Int64(), nil
+}
+
+func (s *redisQuestionStore) Fail(user string, ttl time.Duration) (int64, error) {
+	v, err := g.Redis().Eval(context.TODO(), incrFailuresScript, 1,
+		[]string{s.failuresKey(user)}, []interface{}{int64(ttl.Seconds())})
+	if err != nil {
+		return 0, err
+	}
+	return v.Int64(), nil
+}
+
+func (s *redisQuestionStore) ResetFailures(user string) error {
+	_, err := g.Redis().Del(context.TODO(), s.failuresKey(user))
+	return err
+}
+
+func (s *redisQuestionStore) GetChallenge(user string) (string, bool, error) {
+	v, err := g.Redis().Get(context.TODO(), s.challengeKey(user))
+	if err != nil {
+		return "", false, err
+	}
+	if v.IsNil() {
+		return "", false, nil
+	}
+	return v.String(), true, nil
+}
+
+func (s *redisQuestionStore) SetChallenge(user, ids string, ttl time.Duration) error {
+	return g.Redis().SetEX(context.TODO(), s.challengeKey(user), ids, int64(ttl.Seconds()))
+}
+
+func (s *redisQuestionStore) DeleteChallenge(user string) error {
+	_, err := g.Redis().Del(context.TODO(), s.challengeKey(user))
+	return err
+}
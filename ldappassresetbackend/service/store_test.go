@@ -0,0 +1,309 @@
+package service
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	_ "github.com/gogf/gf/contrib/nosql/redis/v2"
+	"github.com/gogf/gf/v2/database/gredis"
+)
+
+var (
+	testRedisOnce sync.Once
+	testRedis     *miniredis.Miniredis
+)
+
+// 启动进程内的 miniredis 并作为默认 Redis，g.Redis() 的实例会被缓存，因此所有测试共用一个服务
+func newTestRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	testRedisOnce.Do(func() {
+		testRedis = miniredis.NewMiniRedis()
+		if err := testRedis.Start(); err != nil {
+			t.Fatalf("start miniredis: %v", err)
+		}
+		gredis.SetConfig(&gredis.Config{Address: testRedis.Addr()})
+	})
+	if testRedis == nil {
+		t.Fatal("miniredis not started")
+	}
+	testRedis.FlushAll()
+	return testRedis
+}
+
+func TestRedisCodeStore(t *testing.T) {
+	mr := newTestRedis(t)
+	s := &redisCodeStore{prefix: "test:"}
+
+	if err := s.Store("alice@example.com", "123456"); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	// 验证码和发送时间必须同时写入且都带有 TTL
+	if ttl := mr.TTL("test:code:alice@example.com"); ttl != codeExpiryDuration {
+		t.Errorf("code ttl = %v, want %v", ttl, codeExpiryDuration)
+	}
+	if ttl := mr.TTL("test:send:alice@example.com"); ttl != minSendInterval {
+		t.Errorf("send ttl = %v, want %v", ttl, minSendInterval)
+	}
+	if v := mr.HGet("test:code:alice@example.com", "tryCount"); v != "0" {
+		t.Errorf("tryCount = %q, want 0", v)
+	}
+
+	allowed, err := s.AllowedToSend("alice@example.com")
+	if err != nil || allowed {
+		t.Errorf("AllowedToSend = %v, %v; want false within send interval", allowed, err)
+	}
+	mr.FastForward(minSendInterval)
+	if allowed, _ = s.AllowedToSend("alice@example.com"); !allowed {
+		t.Error("AllowedToSend = false after send interval")
+	}
+
+	ok, err := s.Verify("alice@example.com", "000000")
+	if err != nil || ok {
+		t.Errorf("Verify wrong code = %v, %v", ok, err)
+	}
+	if ok, _ = s.Verify("alice@example.com", "123456"); !ok {
+		t.Error("Verify correct code = false")
+	}
+	if mr.Exists("test:code:alice@example.com") {
+		t.Error("code kept after successful Verify")
+	}
+
+	// 重新发送会覆盖旧验证码并清零尝试次数
+	if err := s.Store("alice@example.com", "654321"); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if v := mr.HGet("test:code:alice@example.com", "tryCount"); v != "0" {
+		t.Errorf("tryCount after resend = %q, want 0", v)
+	}
+	if ok, _ = s.Verify("alice@example.com", "123456"); ok {
+		t.Error("old code still valid after resend")
+	}
+
+	if err := s.Delete("alice@example.com"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if ok, _ = s.Verify("alice@example.com", "654321"); ok {
+		t.Error("Verify after Delete = true")
+	}
+}
+
+// 并发提交同一个验证码时只有一个请求能通过
+func TestCodeStoreVerifyOnce(t *testing.T) {
+	newTestRedis(t)
+	stores := map[string]CodeStore{
+		"memory": &memoryCodeStore{store: make(map[string]codeData)},
+		"redis":  &redisCodeStore{prefix: "test:"},
+	}
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			if err := s.Store("alice@example.com", "123456"); err != nil {
+				t.Fatalf("Store: %v", err)
+			}
+			var (
+				wg     sync.WaitGroup
+				passed atomic.Int32
+			)
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if ok, _ := s.Verify("alice@example.com", "123456"); ok {
+						passed.Add(1)
+					}
+				}()
+			}
+			wg.Wait()
+			if n := passed.Load(); n != 1 {
+				t.Errorf("%d concurrent verifications passed, want 1", n)
+			}
+		})
+	}
+}
+
+func TestRedisCodeStoreMaxTries(t *testing.T) {
+	newTestRedis(t)
+	s := &redisCodeStore{prefix: "test:"}
+
+	if err := s.Store("13800000000", "123456"); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	for i := 0; i < maxTryCount; i++ {
+		if ok, _ := s.Verify("13800000000", "000000"); ok {
+			t.Fatalf("wrong code accepted on try %d", i)
+		}
+	}
+	if ok, _ := s.Verify("13800000000", "123456"); ok {
+		t.Error("correct code accepted after max tries")
+	}
+}
+
+func TestRedisCodeStoreExpiry(t *testing.T) {
+	mr := newTestRedis(t)
+	s := &redisCodeStore{prefix: "test:"}
+
+	if err := s.Store("bob@example.com", "123456"); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	mr.FastForward(codeExpiryDuration + time.Second)
+	if ok, _ := s.Verify("bob@example.com", "123456"); ok {
+		t.Error("expired code accepted")
+	}
+}
+
+// 假用户只记录发送时间，不保存验证码
+func TestCodeStoreMarkSent(t *testing.T) {
+	mr := newTestRedis(t)
+	stores := map[string]CodeStore{
+		"memory": &memoryCodeStore{store: make(map[string]codeData)},
+		"redis":  &redisCodeStore{prefix: "test:"},
+	}
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			if err := s.MarkSent("privacy:alice@example.com"); err != nil {
+				t.Fatalf("MarkSent: %v", err)
+			}
+			if allowed, err := s.AllowedToSend("privacy:alice@example.com"); err != nil || allowed {
+				t.Errorf("AllowedToSend = %v, %v; want false within send interval", allowed, err)
+			}
+			// 空验证码同样不能通过校验
+			for _, code := range []string{"", "123456"} {
+				if ok, _ := s.Verify("privacy:alice@example.com", code); ok {
+					t.Errorf("Verify(%q) = true without stored code", code)
+				}
+			}
+			if allowed, _ := s.AllowedToSend("privacy:alice@example.com"); allowed {
+				t.Error("AllowedToSend = true after Verify")
+			}
+		})
+	}
+	if mr.Exists("test:code:privacy:alice@example.com") {
+		t.Error("MarkSent stored a code")
+	}
+	if ttl := mr.TTL("test:send:privacy:alice@example.com"); ttl != minSendInterval {
+		t.Errorf("send ttl = %v, want %v", ttl, minSendInterval)
+	}
+}
+
+func TestRedisCaptchaStore(t *testing.T) {
+	mr := newTestRedis(t)
+	s := &redisCaptchaStore{prefix: "test:"}
+
+	if err := s.Set("id1", "abcd"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	answer, ok, err := s.Get("id1")
+	if err != nil || !ok || answer != "abcd" {
+		t.Errorf("Get = %q, %v, %v", answer, ok, err)
+	}
+	if _, ok, _ = s.Get("missing"); ok {
+		t.Error("Get missing id = true")
+	}
+	mr.FastForward(captchaExpiryDuration + time.Second)
+	if _, ok, _ = s.Get("id1"); ok {
+		t.Error("expired captcha still present")
+	}
+}
+
+func TestRedisTokenStore(t *testing.T) {
+	newTestRedis(t)
+	s := &redisTokenStore{prefix: "test:"}
+
+	if err := s.Save("token1", time.Minute); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if ok, err := s.Consume("token1"); err != nil || !ok {
+		t.Errorf("first Consume = %v, %v", ok, err)
+	}
+	if ok, _ := s.Consume("token1"); ok {
+		t.Error("token consumed twice")
+	}
+}
+
+func TestRedisTOTPStore(t *testing.T) {
+	newTestRedis(t)
+	s := &redisTOTPStore{prefix: "test:"}
+
+	if _, ok, _ := s.GetSecret("cn=alice"); ok {
+		t.Error("GetSecret before SetSecret = true")
+	}
+	if err := s.SetSecret("cn=alice", "secret"); err != nil {
+		t.Fatalf("SetSecret: %v", err)
+	}
+	if secret, ok, _ := s.GetSecret("cn=alice"); !ok || secret != "secret" {
+		t.Errorf("GetSecret = %q, %v", secret, ok)
+	}
+	if ok, _ := s.MarkUsed("cn=alice", 42, time.Minute); !ok {
+		t.Error("first MarkUsed = false")
+	}
+	if ok, _ := s.MarkUsed("cn=alice", 42, time.Minute); ok {
+		t.Error("time step reused")
+	}
+}
+
+func TestRedisQuestionStore(t *testing.T) {
+	mr := newTestRedis(t)
+	s := &redisQuestionStore{prefix: "test:"}
+
+	if err := s.SetAnswers("cn=alice", "answers"); err != nil {
+		t.Fatalf("SetAnswers: %v", err)
+	}
+	if answers, ok, _ := s.GetAnswers("cn=alice"); !ok || answers != "answers" {
+		t.Errorf("GetAnswers = %q, %v", answers, ok)
+	}
+	for i := int64(1); i <= 3; i++ {
+		if n, err := s.Fail("cn=alice", time.Minute); err != nil || n != i {
+			t.Errorf("Fail = %d, %v; want %d", n, err, i)
+		}
+	}
+	if ttl := mr.TTL("test:questions:failures:cn=alice"); ttl != time.Minute {
+		t.Errorf("failures ttl = %v, want %v", ttl, time.Minute)
+	}
+	if err := s.ResetFailures("cn=alice"); err != nil {
+		t.Fatalf("ResetFailures: %v", err)
+	}
+	if n, _ := s.Failures("cn=alice"); n != 0 {
+		t.Errorf("Failures after reset = %d", n)
+	}
+
+	if err := s.SetChallenge("cn=alice", `["q1","q2"]`, time.Minute); err != nil {
+		t.Fatalf("SetChallenge: %v", err)
+	}
+	if ids, ok, _ := s.GetChallenge("cn=alice"); !ok || ids != `["q1","q2"]` {
+		t.Errorf("GetChallenge = %q, %v", ids, ok)
+	}
+	if ttl := mr.TTL("test:questions:challenge:cn=alice"); ttl != time.Minute {
+		t.Errorf("challenge ttl = %v, want %v", ttl, time.Minute)
+	}
+	if err := s.DeleteChallenge("cn=alice"); err != nil {
+		t.Fatalf("DeleteChallenge: %v", err)
+	}
+	if _, ok, _ := s.GetChallenge("cn=alice"); ok {
+		t.Error("GetChallenge after delete = true")
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	code := &memoryCodeStore{store: make(map[string]codeData)}
+	captcha := &memoryCaptchaStore{store: make(map[string]captchaData)}
+
+	_ = code.Store("alice@example.com", "123456")
+	_ = captcha.Set("id1", "abcd")
+
+	// 未过期的记录不会被清理
+	now := time.Now()
+	code.sweep(now)
+	captcha.sweep(now)
+	if len(code.store) != 1 || len(captcha.store) != 1 {
+		t.Fatalf("sweep removed live entries: code=%d captcha=%d", len(code.store), len(captcha.store))
+	}
+
+	later := now.Add(codeExpiryDuration + captchaExpiryDuration)
+	code.sweep(later)
+	captcha.sweep(later)
+	if len(code.store) != 0 || len(captcha.store) != 0 {
+		t.Errorf("sweep kept expired entries: code=%d captcha=%d", len(code.store), len(captcha.store))
+	}
+}
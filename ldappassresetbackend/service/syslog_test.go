@@ -0,0 +1,136 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 5424: <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+var syslogLinePattern = regexp.MustCompile(`^<(\d{1,3})>1 (\S+) (\S+) (\S+) (\d+) (\S+) - (.*)$`)
+
+func parseSyslogLine(t *testing.T, line string) []string {
+	t.Helper()
+	m := syslogLinePattern.FindStringSubmatch(line)
+	if m == nil {
+		t.Fatalf("line %q is not RFC 5424", line)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, m[2]); err != nil {
+		t.Errorf("timestamp %q: %v", m[2], err)
+	}
+	return m
+}
+
+func TestSyslogFormat(t *testing.T) {
+	w := &syslogWriter{facility: 10, appName: "ldap-password-reset", hostname: "host1"}
+	tests := []struct {
+		severity int
+		msgID    string
+		pri      int
+	}{
+		{syslogSeverityInfo, "password_reset", 10*8 + 6},
+		{syslogSeverityWarning, "code_verify_failed", 10*8 + 4},
+	}
+	for _, tt := range tests {
+		m := parseSyslogLine(t, w.format(tt.severity, tt.msgID, `{"user":"alice"}`))
+		if pri, _ := strconv.Atoi(m[1]); pri != tt.pri {
+			t.Errorf("PRI = %d, want %d", pri, tt.pri)
+		}
+		if m[3] != "host1" || m[4] != "ldap-password-reset" || m[6] != tt.msgID || m[7] != `{"user":"alice"}` {
+			t.Errorf("header = %q", m[1:])
+		}
+		if m[5] != strconv.Itoa(os.Getpid()) {
+			t.Errorf("PROCID = %s, want %d", m[5], os.Getpid())
+		}
+	}
+}
+
+func TestSyslogSendUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	w := newSyslogWriter("udp", pc.LocalAddr().String(), 16, "app", 0)
+	if err := w.Send(syslogSeverityInfo, "password_reset", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := parseSyslogLine(t, string(buf[:n]))
+	if m[1] != "134" || m[6] != "password_reset" || m[7] != "hello" {
+		t.Errorf("received %q", buf[:n])
+	}
+}
+
+// TCP 使用 RFC 6587 八位组计数分帧
+func TestSyslogSendTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	w := newSyslogWriter("tcp", ln.Addr().String(), 16, "app", 0)
+	for i := 0; i < 2; i++ {
+		if err := w.Send(syslogSeverityInfo, "unlock", fmt.Sprintf("event %d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	for i := 0; i < 2; i++ {
+		length, err := reader.ReadString(' ')
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(length))
+		if err != nil {
+			t.Fatalf("frame length %q: %v", length, err)
+		}
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(reader, frame); err != nil {
+			t.Fatal(err)
+		}
+		if m := parseSyslogLine(t, string(frame)); m[7] != fmt.Sprintf("event %d", i) {
+			t.Errorf("frame %d = %q", i, frame)
+		}
+	}
+}
+
+// 队列已满时立即丢弃，不阻塞调用方
+func TestSyslogSendQueueFull(t *testing.T) {
+	// 不启动后台协程，模拟 syslog 服务不可用
+	w := &syslogWriter{network: "udp", hostname: "-", appName: "app", queue: make(chan string, 1)}
+	if err := w.Send(syslogSeverityInfo, "a", "first"); err != nil {
+		t.Fatalf("first Send = %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- w.Send(syslogSeverityInfo, "b", "second") }()
+	select {
+	case err := <-done:
+		if err != errSyslogQueueFull {
+			t.Errorf("Send on full queue = %v, want errSyslogQueueFull", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Send blocked on full queue")
+	}
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// 重置令牌有效期
+const resetTokenExpiryDuration = 10 * time.Minute
+
+// ErrInvalidToken 重置令牌无效、过期、已使用或与当前请求不匹配
+var ErrInvalidToken = errors.New("invalid token")
+
+// resetClaims 重置令牌内容，绑定用户、验证方式和客户端指纹
+type resetClaims struct {
+	ID          string   `json:"jti"`
+	DN          string   `json:"dn"`
+	Channel     string   `json:"ch"`
+	Factors     []string `json:"fa,omitempty"` // 本次重置会话中已通过的全部验证方式
+	Fingerprint string   `json:"fp"`
+	Expires     int64    `json:"exp"`
+}
+
+var (
+	tokenSecret     []byte
+	tokenSecretOnce sync.Once
+)
+
+// 签名密钥，未配置时每次启动随机生成，多副本部署时必须配置相同的 token.secret
+func getTokenSecret() []byte {
+	tokenSecretOnce.Do(func() {
+		secret := g.Cfg().MustGet(context.TODO(), "token.secret").String()
+		if secret != "" {
+			tokenSecret = []byte(secret)
+			return
+		}
+		tokenSecret = make([]byte, 32)
+		if _, err := rand.Read(tokenSecret); err != nil {
+			panic(err)
+		}
+	})
+	return tokenSecret
+}
+
+// 客户端指纹：IP 与 User-Agent 的摘要
+func clientFingerprint(r *ghttp.Request) string {
+	sum := sha256.Sum256([]byte(clientIP(r) + "|" + r.UserAgent()))
+	return hex.EncodeToString(sum[:16])
+}
+
+func signToken(payload string) string {
+	mac := hmac.New(sha256.New, getTokenSecret())
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// IssueResetToken 验证码校验通过后签发一次性重置令牌，factors 为本次会话已通过的验证方式
+func IssueResetToken(r *ghttp.Request, userDN, channel string, factors []string) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	claims := resetClaims{
+		ID:          hex.EncodeToString(id),
+		DN:          userDN,
+		Channel:     channel,
+		Factors:     factors,
+		Fingerprint: clientFingerprint(r),
+		Expires:     time.Now().Add(resetTokenExpiryDuration).Unix(),
+	}
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	if err := getTokenStore().Save(claims.ID, resetTokenExpiryDuration); err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + signToken(payload), nil
+}
+
+// parseResetToken 校验签名、有效期及客户端指纹，不消费令牌
+func parseResetToken(r *ghttp.Request, token string) (*resetClaims, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(signature), []byte(signToken(payload))) {
+		return nil, ErrInvalidToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims resetClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() > claims.Expires {
+		return nil, ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(claims.Fingerprint), []byte(clientFingerprint(r))) {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+// 校验请求中的重置令牌，并确认令牌属于该用户
+func checkResetToken(r *ghttp.Request, user *LDAPUser) (*resetClaims, error) {
+	claims, err := parseResetToken(r, r.Get("token").String())
+	if err != nil {
+		return nil, err
+	}
+	if claims.DN != user.DN {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// 令牌中已通过的验证方式
+func (c *resetClaims) factors() []string {
+	if len(c.Factors) == 0 {
+		return []string{c.Channel}
+	}
+	return c.Factors
+}
+
+// 消费令牌，保证只能使用一次
+func consumeResetToken(claims *resetClaims) error {
+	ok, err := getTokenStore().Consume(claims.ID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// 校验用于绑定其他验证方式的重置令牌，不能使用待绑定方式本身签发的令牌，不消费令牌，
+// 由调用方在绑定完成时消费。令牌同样需要满足多因素策略，避免只通过一种方式就绑定新的验证方式来凑齐要求
+func checkEnrollToken(r *ghttp.Request, user *LDAPUser, channel string) (*resetClaims, error) {
+	claims, err := checkResetToken(r, user)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range claims.factors() {
+		if f == channel {
+			return nil, ErrInvalidToken
+		}
+	}
+	if err := checkFactors(user, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
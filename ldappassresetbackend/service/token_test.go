@@ -0,0 +1,146 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// 构造来自指定地址和 User-Agent 的请求，令牌指纹只依赖这两项
+func newTokenRequest(remote, userAgent string) *ghttp.Request {
+	req := httptest.NewRequest("POST", "/api/reset-password", nil)
+	req.RemoteAddr = remote + ":50000"
+	req.Header.Set("User-Agent", userAgent)
+	return &ghttp.Request{Request: req}
+}
+
+// 使用独立的内存令牌存储
+func setupTokenStore(t *testing.T) {
+	t.Helper()
+	initStores()
+	prev := tokenStore
+	tokenStore = &memoryTokenStore{store: make(map[string]time.Time)}
+	t.Cleanup(func() { tokenStore = prev })
+}
+
+// 用当前密钥重新签名修改后的令牌内容
+func resignToken(t *testing.T, token string, modify func(*resetClaims)) string {
+	t.Helper()
+	payload, _, _ := strings.Cut(token, ".")
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims resetClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		t.Fatal(err)
+	}
+	modify(&claims)
+	data, _ = json.Marshal(claims)
+	payload = base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + signToken(payload)
+}
+
+func TestResetToken(t *testing.T) {
+	setupTokenStore(t)
+	r := newTokenRequest("203.0.113.7", "Mozilla/5.0")
+	const dn = "cn=alice,dc=example,dc=com"
+
+	token, err := IssueResetToken(r, dn, "mail", []string{"mobile", "mail"})
+	if err != nil {
+		t.Fatalf("IssueResetToken: %v", err)
+	}
+	claims, err := parseResetToken(r, token)
+	if err != nil {
+		t.Fatalf("parseResetToken: %v", err)
+	}
+	if claims.DN != dn || claims.Channel != "mail" || strings.Join(claims.factors(), ",") != "mobile,mail" {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	payload, signature, _ := strings.Cut(token, ".")
+	tests := []struct {
+		name  string
+		r     *ghttp.Request
+		token string
+	}{
+		{"empty", r, ""},
+		{"no signature", r, payload},
+		{"bad signature", r, payload + "." + strings.Repeat("A", len(signature))},
+		{"tampered payload", r, base64.RawURLEncoding.EncodeToString([]byte(`{"dn":"cn=admin,dc=example,dc=com"}`)) + "." + signature},
+		{"expired", r, resignToken(t, token, func(c *resetClaims) { c.Expires = time.Now().Add(-time.Second).Unix() })},
+		{"other ip", newTokenRequest("198.51.100.1", "Mozilla/5.0"), token},
+		{"other user agent", newTokenRequest("203.0.113.7", "curl/8.0"), token},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseResetToken(tt.r, tt.token); err != ErrInvalidToken {
+				t.Errorf("parseResetToken = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+// 令牌绑定签发时的用户，绑定新验证方式时不能使用该方式本身签发的令牌
+func TestResetTokenBinding(t *testing.T) {
+	setupTokenStore(t)
+	setTestConfig(t, `{}`)
+	mfaOnce = sync.Once{}
+	t.Cleanup(func() { mfaOnce = sync.Once{} })
+
+	alice := &LDAPUser{DN: "cn=alice,dc=example,dc=com"}
+	bob := &LDAPUser{DN: "cn=bob,dc=example,dc=com"}
+	token, err := IssueResetToken(newTokenRequest("203.0.113.7", "Mozilla/5.0"), alice.DN, "mobile", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newTokenRequest("203.0.113.7", "Mozilla/5.0")
+	r.URL.RawQuery = url.Values{"token": {token}}.Encode()
+
+	if _, err := checkResetToken(r, alice); err != nil {
+		t.Errorf("checkResetToken(alice) = %v", err)
+	}
+	if _, err := checkResetToken(r, bob); err != ErrInvalidToken {
+		t.Errorf("checkResetToken(bob) = %v, want ErrInvalidToken", err)
+	}
+	if _, err := checkEnrollToken(r, alice, "mobile"); err != ErrInvalidToken {
+		t.Errorf("checkEnrollToken(mobile) = %v, want ErrInvalidToken", err)
+	}
+	if _, err := checkEnrollToken(r, alice, "totp"); err != nil {
+		t.Errorf("checkEnrollToken(totp) = %v", err)
+	}
+}
+
+func TestConsumeResetToken(t *testing.T) {
+	setupTokenStore(t)
+	r := newTokenRequest("203.0.113.7", "Mozilla/5.0")
+	token, err := IssueResetToken(r, "cn=alice,dc=example,dc=com", "mail", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := parseResetToken(r, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := consumeResetToken(claims); err != nil {
+		t.Fatalf("first consume: %v", err)
+	}
+	// 签名仍然有效，但已经使用过
+	if err := consumeResetToken(claims); err != ErrInvalidToken {
+		t.Errorf("second consume = %v, want ErrInvalidToken", err)
+	}
+
+	// 未经签发的令牌 ID 不能消费
+	forged := *claims
+	forged.ID = "0000"
+	if err := consumeResetToken(&forged); err != ErrInvalidToken {
+		t.Errorf("consume unknown id = %v, want ErrInvalidToken", err)
+	}
+}
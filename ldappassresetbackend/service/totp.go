@@ -0,0 +1,386 @@
+package service
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gogf/gf/v2/os/gctx"
+)
+
+// TOTP 验证方式：用户在身份验证器应用中绑定 RFC 6238 密钥，校验时直接输入动态口令
+
+const (
+	totpPeriod         = 30 // 时间步长，单位秒
+	totpDigits         = 6
+	totpSecretSize     = 20 // 与 HMAC-SHA1 输出长度一致
+	totpEnrollDuration = 10 * time.Minute
+
+	totpStorageStore = "store" // 保存在验证码存储中
+	totpStorageLDAP  = "ldap"  // 保存在用户条目的属性中
+)
+
+var errTOTPNotEnrolled = errors.New("totp not enrolled")
+
+var (
+	totpOnce      sync.Once
+	totpEnabled   bool
+	totpIssuer    string
+	totpStorage   string
+	totpAttribute string
+	totpWindow    int
+	totpAEAD      cipher.AEAD
+	totpErr       error
+)
+
+func loadTOTPConfig() error {
+	totpOnce.Do(func() {
+		ctx := context.TODO()
+		totpEnabled = g.Cfg().MustGet(ctx, "totp.enabled", false).Bool()
+		if !totpEnabled {
+			return
+		}
+		totpIssuer = g.Cfg().MustGet(ctx, "totp.issuer", "LDAP Password Reset").String()
+		totpStorage = g.Cfg().MustGet(ctx, "totp.storage", totpStorageStore).String()
+		totpAttribute = g.Cfg().MustGet(ctx, "totp.attribute").String()
+		totpWindow = g.Cfg().MustGet(ctx, "totp.window", 1).Int()
+		if totpStorage == totpStorageLDAP && totpAttribute == "" {
+			totpErr = fmt.Errorf("totp.attribute is required when totp.storage is ldap")
+			return
+		}
+
+		// 密钥加密后保存，加密密钥由 totp.encryptionKey 派生，丢失后已绑定的密钥全部失效
+		key := g.Cfg().MustGet(ctx, "totp.encryptionKey").String()
+		if key == "" {
+			totpErr = fmt.Errorf("totp.encryptionKey is required")
+			return
+		}
+		sum := sha256.Sum256([]byte(key))
+		block, err := aes.NewCipher(sum[:])
+		if err != nil {
+			totpErr = err
+			return
+		}
+		totpAEAD, totpErr = cipher.NewGCM(block)
+	})
+	return totpErr
+}
+
+// 是否启用 TOTP，配置错误时视为未启用
+func isTOTPEnabled() bool {
+	if err := loadTOTPConfig(); err != nil {
+		g.Log().Error(gctx.New(), "invalid totp config:", err)
+		return false
+	}
+	return totpEnabled
+}
+
+// 加密数据，附加数据用于把密文绑定到用户，防止复制到其他用户的条目中使用
+func totpSeal(plaintext, additional string) (string, error) {
+	nonce := make([]byte, totpAEAD.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := totpAEAD.Seal(nonce, nonce, []byte(plaintext), []byte(additional))
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func totpOpen(ciphertext, additional string) (string, error) {
+	data, err := base64.RawStdEncoding.DecodeString(ciphertext)
+	if err != nil || len(data) < totpAEAD.NonceSize() {
+		return "", fmt.Errorf("invalid ciphertext")
+	}
+	nonce, sealed := data[:totpAEAD.NonceSize()], data[totpAEAD.NonceSize():]
+	plaintext, err := totpAEAD.Open(nil, nonce, sealed, []byte(additional))
+	if err != nil {
+		return "", fmt.Errorf("invalid ciphertext")
+	}
+	return string(plaintext), nil
+}
+
+// 按 RFC 4226 计算指定时间步的口令
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// 在允许的时钟偏差内查找匹配的时间步
+func matchTOTP(secret []byte, code string, now time.Time) (int64, bool) {
+	current := now.Unix() / totpPeriod
+	for offset := -totpWindow; offset <= totpWindow; offset++ {
+		step := current + int64(offset)
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// 已使用时间步的记录保留到该时间步滑出允许的偏差范围之后
+func totpUsedTTL() time.Duration {
+	return time.Duration((2*totpWindow+2)*totpPeriod) * time.Second
+}
+
+// 读取用户已绑定的密钥
+func loadTOTPSecret(user *LDAPUser) ([]byte, error) {
+	var sealed string
+	if totpStorage == totpStorageLDAP {
+		ldapService, err := GetLDAPService()
+		if err != nil {
+			return nil, err
+		}
+		if sealed, err = ldapService.readUserAttribute(user.DN, totpAttribute); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if sealed, _, err = getTOTPStore().GetSecret(user.DN); err != nil {
+			return nil, err
+		}
+	}
+	if sealed == "" {
+		return nil, errTOTPNotEnrolled
+	}
+	encoded, err := totpOpen(sealed, user.DN)
+	if err != nil {
+		return nil, err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(encoded)
+}
+
+// 保存用户的密钥，覆盖之前的绑定
+func saveTOTPSecret(user *LDAPUser, encoded string) error {
+	sealed, err := totpSeal(encoded, user.DN)
+	if err != nil {
+		return err
+	}
+	if totpStorage == totpStorageLDAP {
+		ldapService, err := GetLDAPService()
+		if err != nil {
+			return err
+		}
+		return ldapService.writeUserAttribute(user.DN, totpAttribute, sealed)
+	}
+	return getTOTPStore().SetSecret(user.DN, sealed)
+}
+
+// 用户是否已绑定 TOTP，隐私模式下对所有用户返回相同结果
+func totpEnrolled(user *LDAPUser) bool {
+	if !isTOTPEnabled() {
+		return false
+	}
+	if isPrivacyMode() {
+		return true
+	}
+	_, err := loadTOTPSecret(user)
+	if err != nil && !errors.Is(err, errTOTPNotEnrolled) {
+		g.Log().Error(gctx.New(), "failed to load totp secret:", err)
+	}
+	return err == nil
+}
+
+// verifyTOTP 校验动态口令，每个时间步只能使用一次
+func verifyTOTP(user *LDAPUser, code string) bool {
+	if user.fake || !isTOTPEnabled() {
+		return false
+	}
+	secret, err := loadTOTPSecret(user)
+	if err != nil {
+		if !errors.Is(err, errTOTPNotEnrolled) {
+			g.Log().Error(gctx.New(), "failed to load totp secret:", err)
+		}
+		return false
+	}
+	step, ok := matchTOTP(secret, code, time.Now())
+	if !ok {
+		return false
+	}
+	fresh, err := getTOTPStore().MarkUsed(user.DN, step, totpUsedTTL())
+	if err != nil {
+		g.Log().Error(gctx.New(), "failed to record totp usage:", err)
+		return false
+	}
+	return fresh
+}
+
+// 读取用户条目的单个属性
+func (s *LDAPService) readUserAttribute(dn, attribute string) (string, error) {
+	var value string
+	err := s.withConn(func(conn *ldap.Conn) error {
+		sr, err := conn.Search(ldap.NewSearchRequest(
+			dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false,
+			"(objectClass=*)", []string{attribute}, nil,
+		))
+		if err != nil {
+			return err
+		}
+		if len(sr.Entries) > 0 {
+			value = sr.Entries[0].GetAttributeValue(attribute)
+		}
+		return nil
+	})
+	return value, err
+}
+
+// 替换用户条目的单个属性
+func (s *LDAPService) writeUserAttribute(dn, attribute, value string) error {
+	return s.withConn(func(conn *ldap.Conn) error {
+		req := ldap.NewModifyRequest(dn, nil)
+		req.Replace(attribute, []string{value})
+		return conn.Modify(req)
+	})
+}
+
+// EnrollTOTP 生成新的 TOTP 密钥，用户在验证器中添加后调用 ConfirmTOTP 完成绑定
+func EnrollTOTP(r *ghttp.Request) {
+	if !isTOTPEnabled() {
+		r.Response.WriteJsonExit(g.Map{"code": 10005, "message": "TOTP is not enabled"})
+		return
+	}
+	username := r.Get("username").String()
+	ldapService, err := GetLDAPService()
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 10007, "message": err.Error()})
+		return
+	}
+	user, err := lookupUser(ldapService, username)
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 10008, "message": err.Error()})
+		return
+	}
+	// 受保护的账户不允许绑定新的验证方式
+	user = checkProtected(r, auditTOTPEnroll, user)
+	if _, err := checkEnrollToken(r, user, "totp"); err != nil {
+		audit(r, auditTOTPEnroll, auditFailure, user, "totp", err.Error())
+		r.Response.WriteJsonExit(g.Map{"code": enrollTokenErrorCode(err), "message": err.Error()})
+		return
+	}
+
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 10024, "message": "Failed to generate secret"})
+		return
+	}
+	encoded := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+
+	// 待确认的密钥加密后交给客户端保存，确认时带回，服务端不保存中间状态
+	expires := time.Now().Add(totpEnrollDuration).Unix()
+	enrollment, err := totpSeal(encoded+"|"+strconv.FormatInt(expires, 10), "enroll:"+user.DN)
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 10024, "message": "Failed to generate secret"})
+		return
+	}
+
+	label := totpIssuer + ":" + user.Username
+	uri := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + label,
+		RawQuery: url.Values{
+			"secret":    {encoded},
+			"issuer":    {totpIssuer},
+			"algorithm": {"SHA1"},
+			"digits":    {strconv.Itoa(totpDigits)},
+			"period":    {strconv.Itoa(totpPeriod)},
+		}.Encode(),
+	}
+	r.Response.WriteJsonExit(g.Map{
+		"code":       200,
+		"secret":     encoded,
+		"uri":        uri.String(),
+		"enrollment": enrollment,
+	})
+}
+
+// ConfirmTOTP 校验验证器生成的口令，通过后保存密钥完成绑定
+func ConfirmTOTP(r *ghttp.Request) {
+	if !isTOTPEnabled() {
+		r.Response.WriteJsonExit(g.Map{"code": 10005, "message": "TOTP is not enabled"})
+		return
+	}
+	username := r.Get("username").String()
+	ldapService, err := GetLDAPService()
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 10007, "message": err.Error()})
+		return
+	}
+	user, err := lookupUser(ldapService, username)
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 10008, "message": err.Error()})
+		return
+	}
+	// 受保护的账户不允许绑定新的验证方式
+	user = checkProtected(r, auditTOTPEnroll, user)
+	claims, err := checkEnrollToken(r, user, "totp")
+	if err != nil {
+		audit(r, auditTOTPEnroll, auditFailure, user, "totp", err.Error())
+		r.Response.WriteJsonExit(g.Map{"code": enrollTokenErrorCode(err), "message": err.Error()})
+		return
+	}
+
+	plaintext, err := totpOpen(r.Get("enrollment").String(), "enroll:"+user.DN)
+	encoded, expiresText, _ := strings.Cut(plaintext, "|")
+	expires, _ := strconv.ParseInt(expiresText, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		audit(r, auditTOTPEnroll, auditFailure, user, "totp", "invalid enrollment")
+		r.Response.WriteJsonExit(g.Map{"code": 10024, "message": "Invalid enrollment"})
+		return
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(encoded)
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 10024, "message": "Invalid enrollment"})
+		return
+	}
+	step, ok := matchTOTP(secret, r.Get("totpCode").String(), time.Now())
+	if !ok {
+		audit(r, auditTOTPEnroll, auditFailure, user, "totp", "invalid code")
+		r.Response.WriteJsonExit(g.Map{"code": 10006, "message": "Invalid code"})
+		return
+	}
+
+	// 口令校验通过后消费令牌，一个令牌只能完成一次绑定
+	if err := consumeResetToken(claims); err != nil {
+		audit(r, auditTOTPEnroll, auditFailure, user, "totp", err.Error())
+		r.Response.WriteJsonExit(g.Map{"code": 10018, "message": err.Error()})
+		return
+	}
+	if err := saveTOTPSecret(user, encoded); err != nil {
+		audit(r, auditTOTPEnroll, auditFailure, user, "totp", err.Error())
+		r.Response.WriteJsonExit(g.Map{"code": 10024, "message": err.Error()})
+		return
+	}
+	// 确认用的口令不能再用于验证
+	if _, err := getTOTPStore().MarkUsed(user.DN, step, totpUsedTTL()); err != nil {
+		g.Log().Error(gctx.New(), "failed to record totp usage:", err)
+	}
+	audit(r, auditTOTPEnroll, auditSuccess, user, "totp", "")
+	r.Response.WriteJsonExit(g.Map{
+		"code":    200,
+		"message": "Success",
+	})
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"encoding/base32"
+	"sync"
+	"testing"
+	"time"
+)
+
+// RFC 6238 附录 B 的 SHA1 测试密钥
+var rfc6238Secret = []byte("12345678901234567890")
+
+// 启用 TOTP，密钥保存在独立的内存存储中，允许前后各一个时间步的偏差
+func setupTOTP(t *testing.T) {
+	t.Helper()
+	setTestConfig(t, `{"totp": {"enabled": true, "storage": "store", "window": 1, "encryptionKey": "test"}}`)
+	totpOnce = sync.Once{}
+	t.Cleanup(func() { totpOnce = sync.Once{} })
+	if err := loadTOTPConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	initStores()
+	prev := totpStore
+	totpStore = &memoryTOTPStore{
+		secrets: make(map[string]string),
+		used:    make(map[string]time.Time),
+	}
+	t.Cleanup(func() { totpStore = prev })
+}
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 给出 8 位口令，6 位口令为其后 6 位
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(rfc6238Secret, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("totpCode(T=%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestMatchTOTPWindow(t *testing.T) {
+	setupTOTP(t)
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / totpPeriod
+
+	tests := []struct {
+		offset int64
+		ok     bool
+	}{
+		{0, true},
+		{-1, true},
+		{1, true},
+		{-2, false},
+		{2, false},
+	}
+	for _, tt := range tests {
+		step, ok := matchTOTP(rfc6238Secret, totpCode(rfc6238Secret, current+tt.offset), now)
+		if ok != tt.ok {
+			t.Errorf("offset %d: match = %v, want %v", tt.offset, ok, tt.ok)
+		}
+		if ok && step != current+tt.offset {
+			t.Errorf("offset %d: step = %d, want %d", tt.offset, step, current+tt.offset)
+		}
+	}
+	if _, ok := matchTOTP(rfc6238Secret, "", now); ok {
+		t.Error("empty code matched")
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	setupTOTP(t)
+	user := &LDAPUser{DN: "cn=alice,dc=example,dc=com"}
+	code := func() string { return totpCode(rfc6238Secret, time.Now().Unix()/totpPeriod) }
+
+	// 未绑定时校验失败
+	if verifyTOTP(user, code()) {
+		t.Fatal("verifyTOTP succeeded before enrollment")
+	}
+
+	encoded := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(rfc6238Secret)
+	if err := saveTOTPSecret(user, encoded); err != nil {
+		t.Fatalf("saveTOTPSecret: %v", err)
+	}
+	// 密钥加密保存，并绑定到用户
+	sealed, _, _ := getTOTPStore().GetSecret(user.DN)
+	if sealed == "" || sealed == encoded {
+		t.Errorf("stored secret = %q, want sealed value", sealed)
+	}
+	if _, err := totpOpen(sealed, "cn=bob,dc=example,dc=com"); err == nil {
+		t.Error("sealed secret opened for another user")
+	}
+
+	current := code()
+	if !verifyTOTP(user, current) {
+		t.Fatal("verifyTOTP rejected current code")
+	}
+	// 同一时间步的口令不能重放
+	if verifyTOTP(user, current) {
+		t.Error("verifyTOTP accepted replayed code")
+	}
+	if current != "000000" && verifyTOTP(user, "000000") {
+		t.Error("verifyTOTP accepted wrong code")
+	}
+
+	// 假用户始终失败
+	if verifyTOTP(fakeUser("nobody"), code()) {
+		t.Error("verifyTOTP succeeded for fake user")
+	}
+}
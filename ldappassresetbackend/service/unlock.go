@@ -0,0 +1,62 @@
+package service
+
+import (
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// UnlockAccount 凭验证码校验后签发的重置令牌解除账户锁定，不修改密码
+func UnlockAccount(r *ghttp.Request) {
+	username := r.Get("username").String()
+	ldapService, err := GetLDAPService()
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 10007, "message": err.Error()})
+		return
+	}
+	// 获取用户信息
+	user, err := lookupUser(ldapService, username)
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 10008, "message": err.Error()})
+		return
+	}
+	// 受保护的账户同样不允许自助解锁
+	user = checkProtected(r, auditAccountUnlock, user)
+	// 隐私模式下不存在或受保护的用户按令牌无效处理
+	if user.fake {
+		r.Response.WriteJsonExit(g.Map{"code": 10018, "message": ErrInvalidToken.Error()})
+		return
+	}
+	// 校验并消费重置令牌
+	claims, err := checkResetToken(r, user)
+	if err != nil {
+		audit(r, auditAccountUnlock, auditFailure, user, "", err.Error())
+		r.Response.WriteJsonExit(g.Map{"code": 10018, "message": err.Error()})
+		return
+	}
+	// 与重置密码相同，特权账户需要通过策略要求的全部验证方式
+	if err := checkFactors(user, claims); err != nil {
+		audit(r, auditAccountUnlock, auditFailure, user, claims.Channel, err.Error())
+		r.Response.WriteJsonExit(g.Map{
+			"code":    10027,
+			"message": err.Error(),
+			"pending": pendingFactors(user, claims.factors()),
+		})
+		return
+	}
+	if err := consumeResetToken(claims); err != nil {
+		audit(r, auditAccountUnlock, auditFailure, user, claims.Channel, err.Error())
+		r.Response.WriteJsonExit(g.Map{"code": 10018, "message": err.Error()})
+		return
+	}
+	// 发起解锁请求，目标为令牌中绑定的账户
+	if err := ldapService.Unlock(claims.DN); err != nil {
+		audit(r, auditAccountUnlock, auditFailure, user, claims.Channel, err.Error())
+		r.Response.WriteJsonExit(g.Map{"code": 10015, "message": err.Error()})
+		return
+	}
+	audit(r, auditAccountUnlock, auditSuccess, user, claims.Channel, "")
+	r.Response.WriteJsonExit(g.Map{
+		"code":    200,
+		"message": "Success",
+	})
+}
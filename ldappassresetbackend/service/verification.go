@@ -2,9 +2,9 @@ package service
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"math/big"
-	"sync"
 	"time"
 
 	"github.com/gogf/gf/os/gctx"
@@ -12,18 +12,6 @@ import (
 	"github.com/gogf/gf/v2/net/ghttp"
 )
 
-var (
-	codeStorage = make(map[string]codeData)
-	mu          sync.Mutex // 确保并发安全
-)
-
-type codeData struct {
-	code     string
-	created  time.Time
-	tryCount int       // 跟踪尝试次数
-	lastSend time.Time // 记录上次发送时间
-}
-
 // 设定超时时间
 const codeExpiryDuration = 5 * time.Minute
 const maxTryCount = 5                    // 最大试错次数
@@ -38,74 +26,40 @@ func GenerateCode() string {
 }
 
 // 存储验证码和生成时间
-func StoreCode(identifier, code string) {
-	mu.Lock()
-	defer mu.Unlock()
-
-	codeStorage[identifier] = codeData{
-		code:     code,
-		created:  time.Now(),
-		tryCount: 0,          // 初始化尝试次数为 0
-		lastSend: time.Now(), // 记录发送时间
-	}
+func StoreCode(identifier, code string) error {
+	return getCodeStore().Store(identifier, code)
 }
 
 // 删除验证码
 func DeleteCode(identifier string) {
-	mu.Lock()
-	defer mu.Unlock()
-
-	delete(codeStorage, identifier)
+	if err := getCodeStore().Delete(identifier); err != nil {
+		g.Log().Error(gctx.New(), "failed to delete code:", err)
+	}
 }
 
-// 验证验证码并检查是否超时和尝试次数
+// 验证验证码并检查是否超时和尝试次数，通过后验证码即失效
 func VerifyCode(identifier, code string) bool {
-	mu.Lock()
-	defer mu.Unlock()
-
-	if storedCodeData, ok := codeStorage[identifier]; ok {
-		// 检查验证码是否过期
-		if time.Since(storedCodeData.created) > codeExpiryDuration {
-			// 超过超时时间，删除验证码
-			delete(codeStorage, identifier)
-			return false
-		}
-
-		// 检查尝试次数是否超出限制
-		if storedCodeData.tryCount >= maxTryCount {
-			// 超出最大尝试次数，删除验证码
-			delete(codeStorage, identifier)
-			return false
-		}
-
-		// 验证验证码是否匹配
-		if storedCodeData.code == code {
-			return true
-		}
-
-		// 验证失败，增加尝试次数
-		storedCodeData.tryCount++
-		codeStorage[identifier] = storedCodeData
+	ok, err := getCodeStore().Verify(identifier, code)
+	if err != nil {
+		g.Log().Error(gctx.New(), "failed to verify code:", err)
+		return false
 	}
-
-	return false
+	return ok
 }
 
 // 最小发送间隔检查
 func isAllowedToSend(identifier string) bool {
-	mu.Lock()
-	defer mu.Unlock()
-
-	if storedCodeData, ok := codeStorage[identifier]; ok {
-		if time.Since(storedCodeData.lastSend) < minSendInterval {
-			return false // 如果时间间隔小于最小发送间隔，返回 false
-		}
+	ok, err := getCodeStore().AllowedToSend(identifier)
+	if err != nil {
+		g.Log().Error(gctx.New(), "failed to check send interval:", err)
+		return false
 	}
-
-	return true // 允许发送验证码
+	return ok
 }
 
 func SendVerificationCode(r *ghttp.Request) {
+	defer padResponseTime(time.Now())
+
 	// 校验验证码
 	if !VerifyCaptcha(r) {
 		r.Response.WriteJson(g.Map{
@@ -117,7 +71,7 @@ func SendVerificationCode(r *ghttp.Request) {
 
 	codeType := r.Get("type").String()
 	username := r.Get("username").String()
-	ldapService, err := NewLDAPService()
+	ldapService, err := GetLDAPService()
 	if err != nil {
 		g.Log().Info(gctx.New(), err.Error())
 		r.Response.WriteJsonExit(g.Map{
@@ -128,9 +82,10 @@ func SendVerificationCode(r *ghttp.Request) {
 	}
 
 	// 获取用户的手机号码和邮箱
-	mobile, mail, name, err := ldapService.GetUser(username)
+	user, err := lookupUser(ldapService, username)
 	if err != nil {
 		g.Log().Info(gctx.New(), err.Error())
+		audit(r, auditCodeSent, auditFailure, nil, codeType, err.Error())
 		if err.Error() == "user not found" {
 			r.Response.WriteJsonExit(g.Map{
 				"code":    10012,
@@ -144,13 +99,20 @@ func SendVerificationCode(r *ghttp.Request) {
 		return
 	}
 
+	// 受保护的账户不发送验证码
+	user = checkProtected(r, auditCodeSent, user)
+
+	// 安全问题无需发送，返回本次需要回答的问题
+	if codeType == "questions" {
+		SendSecurityQuestions(r, user)
+		return
+	}
+
 	// 判断验证方式
-	var identifier string
-	if codeType == "mail" {
-		identifier = mail
-	} else if codeType == "mobile" {
-		identifier = mobile
-	} else {
+	user = privacyContactUser(user, codeType, username)
+	identifier, ok := codeIdentifier(codeType, user)
+	if !ok {
+		audit(r, auditCodeSent, auditFailure, user, codeType, "invalid code type or no contact")
 		r.Response.WriteJsonExit(g.Map{
 			"code":    10005,
 			"message": "Invalid data",
@@ -158,8 +120,14 @@ func SendVerificationCode(r *ghttp.Request) {
 		return
 	}
 
-	// 检查是否可以发送验证码
+	// 假用户使用独立的 key，发送间隔等行为与真实用户保持一致
+	if user.fake {
+		identifier = "privacy:" + identifier
+	}
+
+	// 先检查发送间隔，间隔内的重复请求不消耗限流令牌和短信配额
 	if !isAllowedToSend(identifier) {
+		audit(r, auditCodeSent, auditFailure, user, codeType, "send interval too short")
 		r.Response.WriteJson(g.Map{
 			"code":    10009,
 			"message": "Please wait 60 seconds before requesting a new verification code.",
@@ -167,76 +135,231 @@ func SendVerificationCode(r *ghttp.Request) {
 		return
 	}
 
-	// 生成随机验证码并绑定
-	code := GenerateCode()
-	StoreCode(identifier, code)
-
-	// 更新验证码发送时间
-	mu.Lock()
-	if storedCodeData, ok := codeStorage[identifier]; ok {
-		storedCodeData.lastSend = time.Now()     // 更新 lastSend 时间
-		codeStorage[identifier] = storedCodeData // 重新存储修改后的数据
+	// 按接收方限流，短信另有每日上限，发送成功后才计数
+	checkRateLimit(r, limitByIdentifier, identifier)
+	if codeType == "mobile" {
+		checkSmsDailyLimit(r, identifier)
 	}
-	mu.Unlock()
 
-	// 发送验证码
-	if codeType == "mail" {
-		if err := NewEmailService().SendEmail(name, mail, code); err != nil {
+	// 不存在或受保护的用户不生成验证码也不发送，只记录发送时间使发送间隔与真实用户一致
+	if user.fake {
+		if err := getCodeStore().MarkSent(identifier); err != nil {
+			g.Log().Error(gctx.New(), "failed to mark code sent:", err)
 			r.Response.WriteJsonExit(g.Map{
-				"code":    10010,
-				"message": err.Error(),
+				"code":    10013,
+				"message": "Failed to store verification code",
 			})
 		}
+		// 短信照常计数以保持与真实用户一致
+		if codeType == "mobile" {
+			countSmsSent(identifier)
+		}
+		audit(r, auditCodeSent, auditFailure, user, codeType, "")
 		r.Response.WriteJsonExit(g.Map{
 			"code":    200,
 			"message": "Success",
 		})
-	} else if codeType == "mobile" {
-		if err := SendSms(mobile, code); err != nil {
-			r.Response.WriteJsonExit(g.Map{
-				"code":    10011,
-				"message": err.Error(),
-			})
-		}
+	}
+
+	// 生成随机验证码并绑定，同时记录发送时间
+	code := GenerateCode()
+	if err := StoreCode(identifier, code); err != nil {
+		g.Log().Error(gctx.New(), "failed to store code:", err)
+		audit(r, auditCodeSent, auditFailure, user, codeType, err.Error())
+		r.Response.WriteJsonExit(g.Map{
+			"code":    10013,
+			"message": "Failed to store verification code",
+		})
+		return
+	}
+
+	// 发送验证码
+	if isPrivacyMode() {
+		// 隐私模式下异步发送，避免发送耗时暴露账户是否存在
+		go func() {
+			if err := sendCode(codeType, user, code); err != nil {
+				g.Log().Error(gctx.New(), "failed to send code:", err)
+				return
+			}
+			if codeType == "mobile" {
+				countSmsSent(identifier)
+			}
+		}()
+		audit(r, auditCodeSent, auditSuccess, user, codeType, "sent asynchronously")
 		r.Response.WriteJsonExit(g.Map{
 			"code":    200,
 			"message": "Success",
 		})
 	}
+	if err := sendCode(codeType, user, code); err != nil {
+		audit(r, auditCodeSent, auditFailure, user, codeType, err.Error())
+		errCode := 10010
+		switch codeType {
+		case "mobile":
+			// 网关限流和号码无效单独返回，便于前端提示改用其他方式
+			errCode = smsErrorResponseCode(err)
+		case "wecom":
+			errCode = 10022
+		case "dingtalk":
+			errCode = 10023
+		}
+		r.Response.WriteJsonExit(g.Map{
+			"code":    errCode,
+			"message": err.Error(),
+		})
+	}
+	if codeType == "mobile" {
+		countSmsSent(identifier)
+	}
+	audit(r, auditCodeSent, auditSuccess, user, codeType, "")
+	r.Response.WriteJsonExit(g.Map{
+		"code":    200,
+		"message": "Success",
+	})
+}
+
+// 按验证方式确定验证码的接收方标识，用户不支持该方式时返回 false
+func codeIdentifier(codeType string, user *LDAPUser) (string, bool) {
+	switch codeType {
+	case "mail":
+		// 未设置联系方式的用户不能共用空 key
+		return user.Mail, user.Mail != ""
+	case "mobile":
+		return user.Mobile, user.Mobile != ""
+	case "wecom":
+		if weComAvailable(user) {
+			return weComIdentifier(user), true
+		}
+	case "dingtalk":
+		if dingTalkAvailable(user) {
+			return dingTalkIdentifier(user), true
+		}
+	}
+	return "", false
+}
+
+// 按验证方式发送验证码
+func sendCode(codeType string, user *LDAPUser, code string) error {
+	switch codeType {
+	case "mail":
+		return NewEmailService().SendEmail(user.Name, user.Mail, code)
+	case "mobile":
+		return SendSms(user.Mobile, code)
+	case "wecom":
+		s, err := getWeComService()
+		if err != nil {
+			return err
+		}
+		return s.SendCode(user, code)
+	case "dingtalk":
+		s, err := getDingTalkService()
+		if err != nil {
+			return err
+		}
+		return s.SendCode(user, code)
+	}
+	return fmt.Errorf("unsupported code type %q", codeType)
 }
 
 // 验证发送的验证码
 func VerificationCode(r *ghttp.Request) {
+	defer padResponseTime(time.Now())
+
 	username := r.Get("username").String()
 	codeType := r.Get("type").String()
-	ldapService, err := NewLDAPService()
+	ldapService, err := GetLDAPService()
 	if err != nil {
 		r.Response.WriteJsonExit(g.Map{"code": 10004, "message": "Failed to connect to LDAP"})
 		return
 	}
 
 	// 获取用户的手机号码和邮箱
-	mobile, mail, _, err := ldapService.GetUser(username)
+	user, err := lookupUser(ldapService, username)
 	if err != nil {
+		audit(r, auditCodeVerified, auditFailure, nil, codeType, err.Error())
 		r.Response.WriteJsonExit(g.Map{"code": 10008, "message": err.Error()})
 		return
 	}
+	// 受保护的账户不签发重置令牌
+	user = checkProtected(r, auditCodeVerified, user)
 
-	var identifier string
-	if codeType == "mail" {
-		identifier = mail
-	} else if codeType == "mobile" {
-		identifier = mobile
-	} else {
-		r.Response.WriteJsonExit(g.Map{"code": 10005, "message": "Invalid data"})
+	// 先校验本次会话之前的令牌，令牌无效时不消耗验证码
+	prev, err := priorResetToken(r, user)
+	if err != nil {
+		audit(r, auditCodeVerified, auditFailure, user, codeType, err.Error())
+		r.Response.WriteJsonExit(g.Map{"code": 10018, "message": err.Error()})
 		return
 	}
 
 	code := r.Get("verifyCode").String()
-	g.Log().Info(gctx.New(), username+codeType+code)
-	if VerifyCode(identifier, code) {
-		r.Response.WriteJsonExit(g.Map{"code": 200, "message": "Success"})
+	var verified bool
+	if codeType == "totp" {
+		// TOTP 无需发送，直接校验动态口令，按用户限制尝试频率
+		key := "totp:" + user.DN
+		if user.fake {
+			key = "privacy:totp:" + username
+		}
+		checkRateLimit(r, limitByIdentifier, key)
+		verified = verifyTOTP(user, code)
+	} else if codeType == "questions" {
+		// 安全问题按用户限制尝试频率，连续失败达到上限后锁定
+		key := questionKey(user, username)
+		checkRateLimit(r, limitByIdentifier, "questions:"+key)
+		verified, err = verifySecurityAnswers(user, key, r.Get("answers").MapStrStr())
+		if errors.Is(err, errQuestionsLocked) {
+			audit(r, auditCodeVerified, auditFailure, user, codeType, err.Error())
+			r.Response.WriteJsonExit(g.Map{"code": 10025, "message": err.Error()})
+			return
+		}
+		if err != nil {
+			g.Log().Error(gctx.New(), "failed to verify security answers:", err)
+		}
+	} else {
+		user = privacyContactUser(user, codeType, username)
+		identifier, ok := codeIdentifier(codeType, user)
+		if !ok {
+			r.Response.WriteJsonExit(g.Map{"code": 10005, "message": "Invalid data"})
+			return
+		}
+
+		// 假用户没有保存过验证码，校验必然失败
+		if user.fake {
+			identifier = "privacy:" + identifier
+		}
+
+		// 验证码只能使用一次，校验与删除在存储中原子完成
+		verified = VerifyCode(identifier, code)
+	}
+
+	// 假用户不签发令牌，作为校验逻辑之外的兜底
+	if verified && user.fake {
+		verified = false
+	}
+	if verified {
+		// 验证码通过后再作废之前的令牌，累计已通过的验证方式
+		factors, err := carryOverFactors(prev, codeType)
+		if err != nil {
+			audit(r, auditCodeVerified, auditFailure, user, codeType, err.Error())
+			r.Response.WriteJsonExit(g.Map{"code": 10018, "message": err.Error()})
+			return
+		}
+		// 校验通过后签发重置令牌
+		token, err := IssueResetToken(r, user.DN, codeType, factors)
+		if err != nil {
+			audit(r, auditCodeVerified, auditFailure, user, codeType, err.Error())
+			r.Response.WriteJsonExit(g.Map{"code": 10013, "message": "Failed to issue token"})
+			return
+		}
+		audit(r, auditCodeVerified, auditSuccess, user, codeType, "")
+		// pending 不为空时还需要继续完成其中的验证方式才能重置密码
+		r.Response.WriteJsonExit(g.Map{
+			"code":    200,
+			"message": "Success",
+			"token":   token,
+			"pending": pendingFactors(user, factors),
+		})
 		return
 	}
+	audit(r, auditCodeVerified, auditFailure, user, codeType, "invalid code")
 	r.Response.WriteJsonExit(g.Map{"code": 10006, "message": "Invalid code"})
 }
@@ -0,0 +1,30 @@
+package service
+
+import "testing"
+
+func TestCodeIdentifier(t *testing.T) {
+	user := &LDAPUser{Mail: "alice@example.com", Mobile: "13800000000"}
+	noContact := &LDAPUser{}
+
+	tests := []struct {
+		name     string
+		codeType string
+		user     *LDAPUser
+		want     string
+		wantOK   bool
+	}{
+		{"mail", "mail", user, "alice@example.com", true},
+		{"mobile", "mobile", user, "13800000000", true},
+		{"no mail", "mail", noContact, "", false},
+		{"no mobile", "mobile", noContact, "", false},
+		{"unknown type", "fax", user, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := codeIdentifier(tt.codeType, tt.user)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("codeIdentifier(%q) = %q, %v; want %q, %v", tt.codeType, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// 企业微信渠道：以应用消息的形式把验证码发送到用户的企业微信
+
+type weComService struct {
+	endpoint        string
+	corpID          string
+	secret          string
+	agentID         int
+	resolveByMobile bool // 目录中未配置企业微信账号属性时，按手机号查找成员
+	message         *template.Template
+	tokens          *accessTokenCache
+}
+
+var (
+	weComInstance *weComService
+	weComErr      error
+	weComOnce     sync.Once
+)
+
+// 未启用企业微信时返回 nil
+func getWeComService() (*weComService, error) {
+	weComOnce.Do(func() {
+		ctx := context.TODO()
+		if !g.Cfg().MustGet(ctx, "wecom.enabled", false).Bool() {
+			return
+		}
+		message, err := template.New("wecom").Parse(
+			g.Cfg().MustGet(ctx, "wecom.message", "您的密码重置验证码为 {{.Code}}，5 分钟内有效，请勿泄露给他人。").String())
+		if err != nil {
+			weComErr = fmt.Errorf("invalid wecom.message: %v", err)
+			return
+		}
+		s := &weComService{
+			endpoint:        strings.TrimRight(g.Cfg().MustGet(ctx, "wecom.endpoint", "https://qyapi.weixin.qq.com").String(), "/"),
+			corpID:          g.Cfg().MustGet(ctx, "wecom.corpId").String(),
+			secret:          g.Cfg().MustGet(ctx, "wecom.secret").String(),
+			agentID:         g.Cfg().MustGet(ctx, "wecom.agentId").Int(),
+			resolveByMobile: g.Cfg().MustGet(ctx, "wecom.resolveByMobile", true).Bool(),
+			message:         message,
+		}
+		s.tokens = newAccessTokenCache(s.fetchToken)
+		weComInstance = s
+	})
+	return weComInstance, weComErr
+}
+
+// 用户是否可以使用企业微信渠道
+func weComAvailable(user *LDAPUser) bool {
+	s, err := getWeComService()
+	if err != nil || s == nil {
+		return false
+	}
+	return user.WeCom != "" || (s.resolveByMobile && user.Mobile != "")
+}
+
+// 企业微信渠道的验证码标识，加前缀与邮箱、手机号区分
+func weComIdentifier(user *LDAPUser) string {
+	if user.WeCom != "" {
+		return "wecom:" + user.WeCom
+	}
+	return "wecom:" + user.Mobile
+}
+
+func (s *weComService) fetchToken() (string, time.Duration, error) {
+	var resp struct {
+		imResponse
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	u := s.endpoint + "/cgi-bin/gettoken?corpid=" + url.QueryEscape(s.corpID) + "&corpsecret=" + url.QueryEscape(s.secret)
+	if err := doIMRequest(u, nil, &resp); err != nil {
+		return "", 0, err
+	}
+	// 错误由 call 统一加上渠道前缀
+	if err := resp.err(); err != nil {
+		return "", 0, err
+	}
+	return resp.AccessToken, time.Duration(resp.ExpiresIn) * time.Second, nil
+}
+
+func (s *weComService) call(path string, body interface{}, out imResult) error {
+	if err := callWithToken(s.tokens, s.endpoint+path, body, out); err != nil {
+		return fmt.Errorf("wecom: %v", err)
+	}
+	return nil
+}
+
+// 按手机号查找企业微信成员账号
+func (s *weComService) userIDByMobile(mobile string) (string, error) {
+	var resp struct {
+		imResponse
+		UserID string `json:"userid"`
+	}
+	if err := s.call("/cgi-bin/user/getuserid", map[string]string{"mobile": mobile}, &resp); err != nil {
+		return "", err
+	}
+	return resp.UserID, nil
+}
+
+// SendCode 以应用消息发送验证码
+func (s *weComService) SendCode(user *LDAPUser, code string) error {
+	userID := user.WeCom
+	if userID == "" {
+		if !s.resolveByMobile || user.Mobile == "" {
+			return fmt.Errorf("user has no wecom account")
+		}
+		var err error
+		if userID, err = s.userIDByMobile(user.Mobile); err != nil {
+			return err
+		}
+	}
+
+	var content bytes.Buffer
+	if err := s.message.Execute(&content, struct {
+		User string
+		Code string
+	}{
+		User: user.Name,
+		Code: code,
+	}); err != nil {
+		return err
+	}
+
+	var resp struct {
+		imResponse
+		InvalidUser string `json:"invaliduser"`
+	}
+	err := s.call("/cgi-bin/message/send", map[string]interface{}{
+		"touser":  userID,
+		"msgtype": "text",
+		"agentid": s.agentID,
+		"text":    map[string]string{"content": content.String()},
+		"safe":    0,
+	}, &resp)
+	if err != nil {
+		return err
+	}
+	// 成员不存在或不在应用可见范围内时接口仍返回成功
+	if resp.InvalidUser != "" {
+		return fmt.Errorf("wecom user is invalid or out of the app's visible range")
+	}
+	return nil
+}
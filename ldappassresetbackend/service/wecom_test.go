@@ -0,0 +1,152 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+)
+
+// 模拟企业微信接口，sendErrors 按顺序作为应用消息接口的 errcode 返回
+type fakeWeCom struct {
+	mu          sync.Mutex
+	tokenCalls  int
+	tokenErr    int
+	invalidUser string
+	sendErrors  []int
+	sendTokens  []string
+	sendBodies  []map[string]interface{}
+	mobileCalls []string
+}
+
+func (f *fakeWeCom) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/cgi-bin/gettoken":
+		if r.URL.Query().Get("corpid") != "corp" || r.URL.Query().Get("corpsecret") != "secret" {
+			http.Error(w, "bad credentials", http.StatusBadRequest)
+			return
+		}
+		if f.tokenErr != 0 {
+			fmt.Fprintf(w, `{"errcode":%d,"errmsg":"invalid corpid"}`, f.tokenErr)
+			return
+		}
+		f.tokenCalls++
+		fmt.Fprintf(w, `{"errcode":0,"errmsg":"ok","access_token":"token-%d","expires_in":7200}`, f.tokenCalls)
+	case "/cgi-bin/user/getuserid":
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.mobileCalls = append(f.mobileCalls, body["mobile"])
+		fmt.Fprintf(w, `{"errcode":0,"errmsg":"ok","userid":"uid-%s"}`, body["mobile"])
+	case "/cgi-bin/message/send":
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.sendTokens = append(f.sendTokens, r.URL.Query().Get("access_token"))
+		f.sendBodies = append(f.sendBodies, body)
+		code := 0
+		if len(f.sendErrors) > 0 {
+			code, f.sendErrors = f.sendErrors[0], f.sendErrors[1:]
+		}
+		fmt.Fprintf(w, `{"errcode":%d,"errmsg":"msg","invaliduser":%q}`, code, f.invalidUser)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestWeCom(t *testing.T, resolveByMobile bool) (*weComService, *fakeWeCom) {
+	t.Helper()
+	fake := &fakeWeCom{}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	s := &weComService{
+		endpoint:        srv.URL,
+		corpID:          "corp",
+		secret:          "secret",
+		agentID:         1000002,
+		resolveByMobile: resolveByMobile,
+		message:         template.Must(template.New("wecom").Parse("{{.User}} 验证码 {{.Code}}")),
+	}
+	s.tokens = newAccessTokenCache(s.fetchToken)
+	return s, fake
+}
+
+func TestWeComSendCode(t *testing.T) {
+	s, fake := newTestWeCom(t, true)
+
+	if err := s.SendCode(&LDAPUser{Name: "Alice", WeCom: "alice01"}, "123456"); err != nil {
+		t.Fatalf("SendCode: %v", err)
+	}
+	if err := s.SendCode(&LDAPUser{Name: "Bob", Mobile: "13800000000"}, "654321"); err != nil {
+		t.Fatalf("SendCode by mobile: %v", err)
+	}
+	if fake.sendBodies[0]["touser"] != "alice01" || fake.sendBodies[1]["touser"] != "uid-13800000000" {
+		t.Errorf("touser = %v, %v", fake.sendBodies[0]["touser"], fake.sendBodies[1]["touser"])
+	}
+	text, _ := fake.sendBodies[0]["text"].(map[string]interface{})
+	if text["content"] != "Alice 验证码 123456" {
+		t.Errorf("content = %v", text["content"])
+	}
+	if len(fake.mobileCalls) != 1 || fake.mobileCalls[0] != "13800000000" {
+		t.Errorf("getuserid calls = %v", fake.mobileCalls)
+	}
+	// 令牌在多次调用间复用
+	if fake.tokenCalls != 1 {
+		t.Errorf("gettoken called %d times, want 1", fake.tokenCalls)
+	}
+}
+
+func TestWeComAccessTokenRefresh(t *testing.T) {
+	s, fake := newTestWeCom(t, true)
+	fake.sendErrors = []int{imErrExpiredToken}
+
+	if err := s.SendCode(&LDAPUser{WeCom: "alice01"}, "123456"); err != nil {
+		t.Fatalf("SendCode: %v", err)
+	}
+	if strings.Join(fake.sendTokens, ",") != "token-1,token-2" {
+		t.Errorf("send tokens = %v", fake.sendTokens)
+	}
+}
+
+func TestWeComErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		tokenErr    int
+		sendErr     int
+		invalidUser string
+		want        string
+	}{
+		{"gettoken", 40013, 0, "", "wecom: error 40013: invalid corpid"},
+		{"send", 0, 81013, "", "wecom: error 81013: msg"},
+		{"invalid user", 0, 0, "alice01", "wecom user is invalid or out of the app's visible range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fake := newTestWeCom(t, true)
+			fake.tokenErr = tt.tokenErr
+			fake.invalidUser = tt.invalidUser
+			if tt.sendErr != 0 {
+				fake.sendErrors = []int{tt.sendErr}
+			}
+			err := s.SendCode(&LDAPUser{WeCom: "alice01"}, "123456")
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("SendCode = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	// 关闭按手机号查找后，没有企业微信账号的用户不可用
+	s, fake := newTestWeCom(t, false)
+	if err := s.SendCode(&LDAPUser{Mobile: "13800000000"}, "123456"); err == nil {
+		t.Error("SendCode without wecom account succeeded")
+	}
+	if fake.tokenCalls != 0 {
+		t.Errorf("gettoken called %d times", fake.tokenCalls)
+	}
+}
@@ -9,7 +9,6 @@ export default defineConfig({
   // server: {
   //   proxy: {
   //     '/api': 'http://127.0.0.1:8000',  // 假设 GoFrame 运行在 8000 端口
-  //     '/captcha': 'http://localhost:8000',  // 假设 GoFrame 运行在 8000 端
   //   },
   // },
 })